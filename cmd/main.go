@@ -2,13 +2,27 @@ package main
 
 import (
 	"context"
+	"log/slog"
 	"mmf/config"
+	"mmf/internal/constants"
+	"mmf/internal/logging"
 	"mmf/internal/redis"
 	"mmf/internal/server"
+	"mmf/internal/tracing"
+	"os"
 )
 
 func main() {
 	config := config.NewConfig()
+	logging.Init(config.Log)
+	for _, queue := range constants.GetAllQueueTypes() {
+		if _, ok := config.Dota2Lobbies[queue.String()]; constants.IsDota2Queue(queue.String()) && !ok {
+			slog.Error("No lobby template for Dota 2 queue, set it in D2_LOBBIES", "queue", queue.String())
+			os.Exit(1)
+		}
+	}
+	shutdownTracing := tracing.Init(config.Tracing)
+	defer shutdownTracing(context.Background())
 	redis.Init(config, context.Background())
 	server := server.NewServer(config)
 	server.Start()
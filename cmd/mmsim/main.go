@@ -0,0 +1,478 @@
+// mmsim replays a stream of queue arrivals through the evaluator on a
+// simulated clock and reports wait times, match quality and rating spread,
+// so MMRConfig can be tuned before deploying.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"mmf/config"
+	"mmf/internal/calculation"
+	"mmf/internal/constants"
+	"mmf/internal/model"
+)
+
+// Arrival is a player joining the queue, At is in seconds from the start of the simulation
+type Arrival struct {
+	At                int64                     `json:"at"`
+	Id                string                    `json:"id"`
+	Elo               float64                   `json:"elo"`
+	Regions           []string                  `json:"regions,omitempty"`
+	Pings             map[string]int            `json:"pings,omitempty"`
+	Roles             []int                     `json:"roles,omitempty"`
+	LichessCustomData []model.LichessCustomData `json:"lichessCustomData,omitempty"`
+}
+
+type scenario struct {
+	queue        constants.QueueType
+	duration     int64
+	acceptRate   float64
+	responseTime int64 // Longest time a player takes to accept or decline
+	seed         int64
+}
+
+type namedConfig struct {
+	Name string
+	MMR  config.MMRConfig
+}
+
+// Report sums up the simulation of one configuration, times are in seconds
+type Report struct {
+	Config          string   `json:"config"`
+	Arrivals        int      `json:"arrivals"`
+	Matches         int      `json:"matches"`
+	MatchedPlayers  int      `json:"matchedPlayers"`
+	DeclinedMatches int      `json:"declinedMatches"`
+	Left            int      `json:"left"`      // Players who declined and left the queue
+	Unmatched       int      `json:"unmatched"` // Players still waiting at the end
+	WaitP50         float64  `json:"waitP50"`
+	WaitP90         float64  `json:"waitP90"`
+	WaitP99         float64  `json:"waitP99"`
+	QualityMean     *float64 `json:"qualityMean,omitempty"` // Team queues only, lichess pairs have no quality
+	QualityP10      *float64 `json:"qualityP10,omitempty"`
+	SpreadMean      float64  `json:"spreadMean"` // Rating difference between the best and worst player of a match
+	SpreadP90       float64  `json:"spreadP90"`
+}
+
+func main() {
+	queue := flag.String("queue", string(constants.CS2Queue), "queue to simulate, cs2queue, d2queue or lcqueue")
+	duration := flag.Duration("duration", time.Hour, "simulated time")
+	rate := flag.Float64("rate", 20, "synthetic arrivals per minute")
+	ratingMean := flag.Float64("rating-mean", 1500, "mean rating of synthetic players")
+	ratingStddev := flag.Float64("rating-stddev", 300, "rating standard deviation of synthetic players")
+	timeControls := flag.String("time-controls", "3+0,5+0,10+0", "lichess time controls synthetic players pick from, minutes+increment")
+	preferences := flag.Int("preferences", 1, "time controls each synthetic lichess player picks")
+	regions := flag.String("regions", "", "regions synthetic players pick one of, no preference when empty")
+	acceptRate := flag.Float64("accept", 0.9, "probability a player accepts a found match")
+	responseTime := flag.Duration("response-time", 10*time.Second, "longest time a player takes to respond to a found match")
+	seed := flag.Int64("seed", 1, "random seed, the same seed replays the same arrivals")
+	arrivalsFile := flag.String("arrivals", "", "recorded arrivals, one JSON object per line, replace synthetic ones")
+	configsFile := flag.String("configs", "", "JSON list of {\"Name\", \"MMR\"} configurations, fields left out keep the flag values")
+	jsonOutput := flag.Bool("json", false, "print reports as JSON")
+
+	base := config.MMRConfig{}
+	flag.StringVar(&base.Mode, "mode", "trueskill", "match quality model, trueskill or glicko")
+	flag.IntVar(&base.Interval, "interval", 5, "seconds between evaluations")
+	flag.IntVar(&base.TeamSize, "team-size", 5, "players per team")
+	flag.Float64Var(&base.Treshold, "threshold", 0.6, "minimum match quality")
+	flag.IntVar(&base.Range, "range", 200, "maximum rating difference within a match")
+	flag.IntVar(&base.RegionRelaxTime, "region-relax-time", 60, "seconds after which neighbouring regions are accepted")
+	flag.IntVar(&base.MaxPing, "max-ping", 0, "highest ping accepted for a region, 0 disables the check")
+	flag.Parse()
+
+	queueType := constants.GetQueueType(*queue)
+	if queueType == "" {
+		exit("Unknown queue", "queue", *queue)
+	}
+	if base.Interval < 1 {
+		exit("Interval must be at least one second")
+	}
+
+	configs := []namedConfig{{Name: "default", MMR: base}}
+	if *configsFile != "" {
+		var err error
+		if configs, err = readConfigs(*configsFile, base); err != nil {
+			exit("Error reading configurations", "file", *configsFile, "error", err)
+		}
+	}
+
+	var arrivals []Arrival
+	if *arrivalsFile != "" {
+		var err error
+		if arrivals, err = readArrivals(*arrivalsFile); err != nil {
+			exit("Error reading arrivals", "file", *arrivalsFile, "error", err)
+		}
+	} else {
+		controls, err := parseTimeControls(*timeControls)
+		if err != nil {
+			exit("Invalid time controls", "error", err)
+		}
+		generator := generator{
+			queue:        queueType,
+			rate:         *rate,
+			ratingMean:   *ratingMean,
+			ratingStddev: *ratingStddev,
+			timeControls: controls,
+			preferences:  *preferences,
+			regions:      splitList(*regions),
+		}
+		arrivals = generator.generate(rand.New(rand.NewSource(*seed)), int64(duration.Seconds()))
+	}
+
+	sc := scenario{
+		queue:        queueType,
+		duration:     int64(duration.Seconds()),
+		acceptRate:   *acceptRate,
+		responseTime: int64(responseTime.Seconds()),
+		seed:         *seed,
+	}
+
+	reports := make([]Report, 0, len(configs))
+	for _, cfg := range configs {
+		reports = append(reports, simulate(sc, cfg, arrivals))
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(reports); err != nil {
+			exit("Error writing reports", "error", err)
+		}
+		return
+	}
+	printReports(sc.queue, reports)
+}
+
+// simulate runs the evaluator every interval over the players waiting at that
+// time. Players of a found match respond within the response time, when someone
+// declines the ones who accepted go back to the queue and the rest leave.
+func simulate(sc scenario, cfg namedConfig, arrivals []Arrival) Report {
+	// Every configuration sees the same responses
+	rng := rand.New(rand.NewSource(sc.seed))
+	interval := int64(cfg.MMR.Interval)
+
+	waiting := make(map[string]model.Ticket)
+	type requeue struct {
+		at     int64
+		ticket model.Ticket
+	}
+	requeues := []requeue{}
+
+	report := Report{Config: cfg.Name, Arrivals: len(arrivals)}
+	var waits, qualities, spreads []float64
+
+	next := 0
+	for now := int64(0); now <= sc.duration; now += interval {
+		for next < len(arrivals) && arrivals[next].At <= now {
+			ticket := arrivals[next].ticket()
+			waiting[ticket.Member.Id] = ticket
+			next++
+		}
+		requeues = slices.DeleteFunc(requeues, func(r requeue) bool {
+			if r.at <= now {
+				waiting[r.ticket.Member.Id] = r.ticket
+				return true
+			}
+			return false
+		})
+
+		tickets := make([]model.Ticket, 0, len(waiting))
+		for _, ticket := range waiting {
+			tickets = append(tickets, ticket)
+		}
+		slices.SortFunc(tickets, func(a, b model.Ticket) int {
+			if a.Score != b.Score {
+				return int(math.Copysign(1, a.Score-b.Score))
+			}
+			return strings.Compare(a.Member.Id, b.Member.Id)
+		})
+
+		for _, candidate := range calculation.FindMatches(cfg.MMR, sc.queue, tickets, now) {
+			if !candidate.Selected {
+				continue
+			}
+
+			players := append(slices.Clone(candidate.Team1), candidate.Team2...)
+			for _, player := range players {
+				delete(waiting, player.Member.Id)
+			}
+
+			// The match is cancelled on the first decline, otherwise it's made
+			accepted := []model.Ticket{}
+			declinedAt := int64(-1)
+			for _, player := range players {
+				respondedAt := now + rng.Int63n(sc.responseTime+1)
+				if rng.Float64() < sc.acceptRate {
+					accepted = append(accepted, player)
+				} else if declinedAt < 0 || respondedAt < declinedAt {
+					declinedAt = respondedAt
+				}
+			}
+
+			if declinedAt >= 0 {
+				report.DeclinedMatches++
+				report.Left += len(players) - len(accepted)
+				for _, player := range accepted {
+					requeues = append(requeues, requeue{at: declinedAt, ticket: player})
+				}
+				continue
+			}
+
+			report.Matches++
+			report.MatchedPlayers += len(players)
+			lowest, highest := players[0].Score, players[0].Score
+			for _, player := range players {
+				waits = append(waits, float64(now-player.Member.JoinedAt))
+				lowest = min(lowest, player.Score)
+				highest = max(highest, player.Score)
+			}
+			if !constants.IsLichessQueue(sc.queue.String()) {
+				qualities = append(qualities, candidate.Quality)
+			}
+			spreads = append(spreads, highest-lowest)
+		}
+	}
+
+	report.Unmatched = len(waiting) + len(requeues)
+	report.WaitP50 = percentile(waits, 0.5)
+	report.WaitP90 = percentile(waits, 0.9)
+	report.WaitP99 = percentile(waits, 0.99)
+	if !constants.IsLichessQueue(sc.queue.String()) {
+		qualityMean, qualityP10 := mean(qualities), percentile(qualities, 0.1)
+		report.QualityMean, report.QualityP10 = &qualityMean, &qualityP10
+	}
+	report.SpreadMean = mean(spreads)
+	report.SpreadP90 = percentile(spreads, 0.9)
+	return report
+}
+
+func (a Arrival) ticket() model.Ticket {
+	customData := slices.Clone(a.LichessCustomData)
+	for i := range customData {
+		customData[i].Timestamp = a.At
+	}
+
+	return model.Ticket{
+		Member: model.MemberData{
+			Id:                a.Id,
+			LichessCustomData: customData,
+			Regions:           a.Regions,
+			Pings:             a.Pings,
+			Roles:             a.Roles,
+			JoinedAt:          a.At,
+		},
+		Score: a.Elo,
+	}
+}
+
+type timeControl struct {
+	time      int
+	increment int
+}
+
+type generator struct {
+	queue        constants.QueueType
+	rate         float64 // Arrivals per minute
+	ratingMean   float64
+	ratingStddev float64
+	timeControls []timeControl
+	preferences  int
+	regions      []string
+}
+
+// generate returns Poisson distributed arrivals with normally distributed ratings
+func (g generator) generate(rng *rand.Rand, duration int64) []Arrival {
+	arrivals := []Arrival{}
+	if g.rate <= 0 {
+		return arrivals
+	}
+
+	at := 0.0
+	for {
+		at += rng.ExpFloat64() * 60 / g.rate
+		if int64(at) > duration {
+			return arrivals
+		}
+
+		// Ids are numeric like steam ids, trueskill requires it
+		arrival := Arrival{
+			At:  int64(at),
+			Id:  strconv.Itoa(len(arrivals) + 1),
+			Elo: math.Max(0, math.Round(rng.NormFloat64()*g.ratingStddev+g.ratingMean)),
+		}
+		if len(g.regions) > 0 {
+			arrival.Regions = []string{g.regions[rng.Intn(len(g.regions))]}
+		}
+		if g.queue == constants.D2Queue {
+			arrival.Roles = rng.Perm(5)
+			for i := range arrival.Roles {
+				arrival.Roles[i]++
+			}
+		}
+		if constants.IsLichessQueue(g.queue.String()) {
+			for _, i := range rng.Perm(len(g.timeControls))[:min(g.preferences, len(g.timeControls))] {
+				arrival.LichessCustomData = append(arrival.LichessCustomData, model.LichessCustomData{
+					Time:       g.timeControls[i].time,
+					Increment:  g.timeControls[i].increment,
+					Collateral: model.USDT,
+					Variant:    model.Standard,
+				})
+			}
+		}
+		arrivals = append(arrivals, arrival)
+	}
+}
+
+func readArrivals(path string) ([]Arrival, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	arrivals := []Arrival{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var arrival Arrival
+		if err := json.Unmarshal(scanner.Bytes(), &arrival); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if arrival.Id == "" {
+			arrival.Id = strconv.Itoa(line)
+		}
+		arrivals = append(arrivals, arrival)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(arrivals, func(a, b Arrival) int {
+		return int(a.At - b.At)
+	})
+	return arrivals, nil
+}
+
+// readConfigs reads the configurations to compare, each starts from the flag values
+func readConfigs(path string, base config.MMRConfig) ([]namedConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	configs := make([]namedConfig, 0, len(raw))
+	for i, message := range raw {
+		cfg := namedConfig{Name: strconv.Itoa(i + 1), MMR: base}
+		if err := json.Unmarshal(message, &cfg); err != nil {
+			return nil, fmt.Errorf("configuration %d: %w", i+1, err)
+		}
+		if cfg.MMR.Interval < 1 {
+			return nil, fmt.Errorf("configuration %s: interval must be at least one second", cfg.Name)
+		}
+		configs = append(configs, cfg)
+	}
+	return configs, nil
+}
+
+func parseTimeControls(value string) ([]timeControl, error) {
+	controls := []timeControl{}
+	for _, control := range splitList(value) {
+		minutes, increment, ok := strings.Cut(control, "+")
+		if !ok {
+			return nil, fmt.Errorf("%s is not minutes+increment", control)
+		}
+		time, err := strconv.Atoi(minutes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", control, err)
+		}
+		inc, err := strconv.Atoi(increment)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", control, err)
+		}
+		controls = append(controls, timeControl{time: time, increment: inc})
+	}
+	if len(controls) == 0 {
+		return nil, fmt.Errorf("no time controls")
+	}
+	return controls, nil
+}
+
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Nearest rank percentile, 0 without values
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(rank, 0)]
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	total := 0.0
+	for _, value := range values {
+		total += value
+	}
+	return total / float64(len(values))
+}
+
+// Lichess pairs have no quality, the rating difference of the players is shown instead
+func printReports(queue constants.QueueType, reports []Report) {
+	spread := "spread"
+	if constants.IsLichessQueue(queue.String()) {
+		spread = "rating diff"
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(writer, "config\tarrivals\tmatches\tmatched\tdeclined\tleft\tunmatched\twait p50\twait p90\twait p99\tquality\tquality p10\t%s\t%s p90\t\n", spread, spread)
+	for _, r := range reports {
+		fmt.Fprintf(writer, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%.0fs\t%.0fs\t%.0fs\t%s\t%s\t%.0f\t%.0f\t\n",
+			r.Config, r.Arrivals, r.Matches, r.MatchedPlayers, r.DeclinedMatches, r.Left, r.Unmatched,
+			r.WaitP50, r.WaitP90, r.WaitP99, formatQuality(r.QualityMean), formatQuality(r.QualityP10), r.SpreadMean, r.SpreadP90)
+	}
+	writer.Flush()
+}
+
+func formatQuality(quality *float64) string {
+	if quality == nil {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.3f", *quality)
+}
+
+func exit(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -1,8 +1,11 @@
 package config
 
 import (
+	"encoding/json"
+	"log/slog"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -22,20 +25,72 @@ type Config struct {
 	MatchEndWebhook     ExternalApiConfig
 	Subgraph            ExternalApiConfig
 	Notifications       ExternalApiConfig
+	MMFApi              ExternalApiConfig
+	CS2Match            CS2MatchConfig
+	Dota2Lobbies        map[string]Dota2LobbyTemplate // Lobby template per queue
+	Log                 LogConfig
+	Tracing             TracingConfig
+	Audit               AuditConfig
+	History             HistoryConfig
+}
+
+type LogConfig struct {
+	Level  string // debug, info, warn or error
+	Format string // text or json
+}
+
+type TracingConfig struct {
+	Endpoint    string // OTLP gRPC collector url, tracing is disabled when empty
+	ServiceName string
+	SampleRatio float64 // Share of traces recorded, between 0 and 1
+}
+
+type AuditConfig struct {
+	MaxLen        int64 // Entries kept in the audit stream of all matches
+	RetentionDays int   // Days the audit of a match or user is kept after its last entry
+}
+
+type HistoryConfig struct {
+	RetentionDays       int // Days a match is kept in the history after its creation
+	ResultRetentionDays int // Days the result reported by the game server is kept
 }
 
 type ServerConfig struct {
-	Port string
+	Port        string
+	GrpcPort    string
+	GrpcApiKey  string // Shared key of backend services, the gRPC api is disabled when empty
+	AdminApiKey string // Key of operators for the admin api, the admin api is disabled when empty
+
+	PlayerTokenSecret string // Signs player tokens of the REST and event stream api, disabled when empty
 }
 
 type MMRConfig struct {
-	Mode              string
-	Interval          int
-	TeamSize          int
-	Treshold          float64
-	Range             int
-	TimeToCancelMatch int
-	TimeToAccept      int
+	Mode                string
+	Interval            int
+	TeamSize            int
+	Treshold            float64
+	Range               int
+	TimeToCancelMatch   int
+	TimeToAccept        int
+	RegionRelaxTime     int // Seconds in queue after which neighbouring regions are accepted
+	MaxPing             int
+	Backfill            bool // Replace players declining a team match instead of dissolving it
+	BackfillTime        int  // Seconds to look for a replacement
+	QueueStatusInterval int  // Seconds between QUEUE_STATUS events, 0 disables them
+	TicketTTL           int  // Seconds a ticket lives without a heartbeat from its owner, 0 disables expiry
+}
+
+type CS2MatchConfig struct {
+	MapPool             []string
+	VetoTimeout         int
+	ConnectTime         int
+	MatchBeginCountdown int
+}
+
+type Dota2LobbyTemplate struct {
+	GameName     string `json:"gameName"`
+	GameMode     string `json:"gameMode"`
+	ServerRegion int    `json:"serverRegion"`
 }
 
 type RedisConfig struct {
@@ -83,11 +138,135 @@ func NewConfig() *Config {
 		timeToAccept = 30 // default
 	}
 
+	regionRelaxTime, err := strconv.Atoi(readEnvVar("MMR_REGION_RELAX_TIME"))
+	if err != nil {
+		regionRelaxTime = 60 // default
+	}
+
+	maxPing, err := strconv.Atoi(readEnvVar("MMR_MAX_PING"))
+	if err != nil {
+		maxPing = 150 // default
+	}
+
+	backfill, err := strconv.ParseBool(readEnvVar("MMR_BACKFILL"))
+	if err != nil {
+		backfill = false // default
+	}
+
+	backfillTime, err := strconv.Atoi(readEnvVar("MMR_BACKFILL_TIME"))
+	if err != nil {
+		backfillTime = 30 // default
+	}
+
+	queueStatusInterval, err := strconv.Atoi(readEnvVar("MMR_QUEUE_STATUS_INTERVAL"))
+	if err != nil {
+		queueStatusInterval = 10 // default
+	}
+
+	ticketTTL, err := strconv.Atoi(readEnvVar("MMR_TICKET_TTL"))
+	if err != nil {
+		ticketTTL = 60 // default
+	}
+
+	sampleRatio, err := strconv.ParseFloat(readEnvVar("OTEL_TRACES_SAMPLE_RATIO"), 64)
+	if err != nil {
+		sampleRatio = 1 // default
+	}
+
+	auditMaxLen, err := strconv.ParseInt(readEnvVar("AUDIT_MAX_LEN"), 10, 64)
+	if err != nil {
+		auditMaxLen = 1000000 // default
+	}
+
+	auditRetentionDays, err := strconv.Atoi(readEnvVar("AUDIT_RETENTION_DAYS"))
+	if err != nil {
+		auditRetentionDays = 90 // default
+	}
+
+	historyRetentionDays, err := strconv.Atoi(readEnvVar("HISTORY_RETENTION_DAYS"))
+	if err != nil {
+		historyRetentionDays = 365 // default
+	}
+
+	resultRetentionDays, err := strconv.Atoi(readEnvVar("RESULT_RETENTION_DAYS"))
+	if err != nil {
+		resultRetentionDays = 90 // default
+	}
+
+	serviceName := readEnvVar("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "mmf" // default
+	}
+
 	rangeInt, err := strconv.Atoi(readEnvVar("MMR_RANGE"))
 	if err != nil {
 		rangeInt = 100 // default
 	}
 
+	mapPool := strings.Split(readEnvVar("CS2_MAP_POOL"), ",")
+	if len(mapPool) == 1 && mapPool[0] == "" {
+		mapPool = []string{"de_ancient", "de_anubis", "de_dust2", "de_inferno", "de_mirage", "de_nuke", "de_vertigo"} // default
+	}
+
+	vetoTimeout, err := strconv.Atoi(readEnvVar("CS2_VETO_TIMEOUT"))
+	if err != nil {
+		vetoTimeout = 20 // default
+	}
+
+	connectTime, err := strconv.Atoi(readEnvVar("CS2_CONNECT_TIME"))
+	if err != nil {
+		connectTime = 120 // default
+	}
+
+	matchBeginCountdown, err := strconv.Atoi(readEnvVar("CS2_MATCH_BEGIN_COUNTDOWN"))
+	if err != nil {
+		matchBeginCountdown = 10 // default
+	}
+
+	d2ServerRegion, err := strconv.Atoi(readEnvVar("D2_SERVER_REGION"))
+	if err != nil {
+		d2ServerRegion = 3 // default, Europe West
+	}
+
+	d2GameMode := readEnvVar("D2_GAME_MODE")
+	if d2GameMode == "" {
+		d2GameMode = "AP" // default
+	}
+
+	logLevel := readEnvVar("LOG_LEVEL")
+	if logLevel == "" {
+		logLevel = "info" // default
+	}
+
+	logFormat := readEnvVar("LOG_FORMAT")
+	if logFormat == "" {
+		logFormat = "text" // default
+	}
+
+	grpcPort := readEnvVar("GRPC_PORT")
+	if grpcPort == "" {
+		grpcPort = "9090" // default
+	}
+
+	d2GameName := readEnvVar("D2_GAME_NAME")
+	if d2GameName == "" {
+		d2GameName = "Showdown" // default
+	}
+
+	// Lobby templates keyed by queue, without them d2queue is configured by the D2_GAME_* variables
+	dota2Lobbies := map[string]Dota2LobbyTemplate{}
+	if lobbies := readEnvVar("D2_LOBBIES"); lobbies != "" {
+		if err := json.Unmarshal([]byte(lobbies), &dota2Lobbies); err != nil {
+			slog.Error("Invalid D2_LOBBIES", "error", err)
+		}
+	} else {
+		dota2Lobbies["d2queue"] = Dota2LobbyTemplate{
+			GameName:     d2GameName,
+			GameMode:     d2GameMode,
+			ServerRegion: d2ServerRegion,
+		}
+	}
+
 	GlobalConfig = &Config{
 		Redis: RedisConfig{
 			Host:     readEnvVar("REDIS_HOST"),
@@ -96,16 +275,27 @@ func NewConfig() *Config {
 			DB:       db,
 		},
 		Server: ServerConfig{
-			Port: readEnvVar("SERVER_PORT"),
+			Port:        readEnvVar("SERVER_PORT"),
+			GrpcPort:    grpcPort,
+			GrpcApiKey:  readEnvVar("GRPC_API_KEY"),
+			AdminApiKey: readEnvVar("ADMIN_API_KEY"),
+
+			PlayerTokenSecret: readEnvVar("PLAYER_TOKEN_SECRET"),
 		},
 		MMRConfig: MMRConfig{
-			Mode:              readEnvVar("MMR_MODE"),
-			Interval:          interval,
-			TeamSize:          teamSize,
-			Treshold:          treshold,
-			TimeToCancelMatch: timeToCancelMatch,
-			TimeToAccept:      timeToAccept,
-			Range:             rangeInt,
+			Mode:                readEnvVar("MMR_MODE"),
+			Interval:            interval,
+			TeamSize:            teamSize,
+			Treshold:            treshold,
+			TimeToCancelMatch:   timeToCancelMatch,
+			TimeToAccept:        timeToAccept,
+			Range:               rangeInt,
+			RegionRelaxTime:     regionRelaxTime,
+			MaxPing:             maxPing,
+			Backfill:            backfill,
+			BackfillTime:        backfillTime,
+			QueueStatusInterval: queueStatusInterval,
+			TicketTTL:           ticketTTL,
 		},
 		EthRpc: ExternalApiConfig{
 			URL: readEnvVar("ETH_RPC_URL"),
@@ -141,6 +331,34 @@ func NewConfig() *Config {
 		Notifications: ExternalApiConfig{
 			URL: readEnvVar("NOTIFICATIONS_URL"),
 		},
+		MMFApi: ExternalApiConfig{
+			URL:    readEnvVar("MMF_URL"),
+			ApiKey: readEnvVar("RESULT_WEBHOOK_TOKEN"), // Game servers report results with it, results are refused when empty
+		},
+		CS2Match: CS2MatchConfig{
+			MapPool:             mapPool,
+			VetoTimeout:         vetoTimeout,
+			ConnectTime:         connectTime,
+			MatchBeginCountdown: matchBeginCountdown,
+		},
+		Dota2Lobbies: dota2Lobbies,
+		Log: LogConfig{
+			Level:  logLevel,
+			Format: logFormat,
+		},
+		Tracing: TracingConfig{
+			Endpoint:    readEnvVar("OTEL_EXPORTER_OTLP_ENDPOINT"),
+			ServiceName: serviceName,
+			SampleRatio: sampleRatio,
+		},
+		Audit: AuditConfig{
+			MaxLen:        auditMaxLen,
+			RetentionDays: auditRetentionDays,
+		},
+		History: HistoryConfig{
+			RetentionDays:       historyRetentionDays,
+			ResultRetentionDays: resultRetentionDays,
+		},
 	}
 
 	return GlobalConfig
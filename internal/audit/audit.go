@@ -0,0 +1,149 @@
+package audit
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"mmf/config"
+	"mmf/internal/clock"
+	"mmf/internal/redis"
+
+	goredis "github.com/go-redis/redis"
+)
+
+type EntryType string
+
+const (
+	TicketEnqueued     EntryType = "TICKET_ENQUEUED"
+	TicketCancelled    EntryType = "TICKET_CANCELLED"
+	TicketExpired      EntryType = "TICKET_EXPIRED"      // Owner stopped sending heartbeats
+	CandidateEvaluated EntryType = "CANDIDATE_EVALUATED" // Players considered for a match, with the quality that decided it
+	MatchCreated       EntryType = "MATCH_CREATED"
+	PlayerAccepted     EntryType = "PLAYER_ACCEPTED"
+	PlayerDeclined     EntryType = "PLAYER_DECLINED"
+	PlayerPaid         EntryType = "PLAYER_PAID"
+	PaymentRejected    EntryType = "PAYMENT_REJECTED" // Transaction sent by the player failed verification
+	AdminAction        EntryType = "ADMIN_ACTION"
+)
+
+// Entry is a matchmaking decision or what happened to a match afterwards,
+// match lifecycle events are recorded with their event type
+type Entry struct {
+	Id        string                 `json:"id,omitempty"` // Id in the stream it was read from
+	Type      EntryType              `json:"type"`
+	Queue     string                 `json:"queue,omitempty"`
+	MatchId   string                 `json:"matchId,omitempty"`
+	UserId    string                 `json:"userId,omitempty"`
+	Players   []string               `json:"players,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	Timestamp int64                  `json:"timestamp"`
+}
+
+const (
+	streamName = "audit"
+	// Entries kept per match or user stream
+	maxLenPerKey = 1000
+)
+
+func matchStream(matchId string) string {
+	return "audit_match_" + matchId
+}
+
+func userStream(userId string) string {
+	return "audit_user_" + userId
+}
+
+// Record appends the entry to the audit stream and to the streams of its
+// match and users. It doesn't fail the caller, errors are only logged.
+func Record(entry Entry) {
+	if entry.Timestamp == 0 {
+		entry.Timestamp = clock.Now().Unix()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		slog.Error("Error marshalling audit entry", "type", entry.Type, "error", err)
+		return
+	}
+	values := map[string]interface{}{"entry": data}
+	auditConfig := config.GlobalConfig.Audit
+	retention := time.Duration(auditConfig.RetentionDays) * 24 * time.Hour
+
+	pipe := redis.RedisClient.Pipeline()
+	pipe.XAdd(&goredis.XAddArgs{Stream: streamName, MaxLenApprox: auditConfig.MaxLen, Values: values})
+
+	keys := []string{}
+	if entry.MatchId != "" {
+		keys = append(keys, matchStream(entry.MatchId))
+	}
+	if entry.UserId != "" {
+		keys = append(keys, userStream(entry.UserId))
+	}
+	for _, playerId := range entry.Players {
+		if playerId != entry.UserId {
+			keys = append(keys, userStream(playerId))
+		}
+	}
+	for _, key := range keys {
+		pipe.XAdd(&goredis.XAddArgs{Stream: key, MaxLenApprox: maxLenPerKey, Values: values})
+		pipe.Expire(key, retention)
+	}
+
+	if _, err := pipe.Exec(); err != nil {
+		slog.Error("Error recording audit entry", "type", entry.Type, "matchId", entry.MatchId, "userId", entry.UserId, "error", err)
+	}
+}
+
+// MatchEntries returns entries of the match newest first, before is the id
+// of the last entry of the previous page
+func MatchEntries(matchId string, before string, count int64) ([]Entry, error) {
+	return readEntries(matchStream(matchId), before, count)
+}
+
+// UserEntries returns entries of the user newest first
+func UserEntries(userId string, before string, count int64) ([]Entry, error) {
+	return readEntries(userStream(userId), before, count)
+}
+
+// Entries returns entries of all matches and users newest first
+func Entries(before string, count int64) ([]Entry, error) {
+	return readEntries(streamName, before, count)
+}
+
+func readEntries(stream string, before string, count int64) ([]Entry, error) {
+	start, limit := "+", count
+	if before != "" {
+		// The range is inclusive, the entry of the cursor is dropped
+		start, limit = before, count+1
+	}
+
+	messages, err := redis.RedisClient.XRevRangeN(stream, start, "-", limit).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(messages))
+	for _, message := range messages {
+		if message.ID == before {
+			continue
+		}
+
+		data, ok := message.Values["entry"].(string)
+		if !ok {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			slog.Error("Error unmarshalling audit entry", "stream", stream, "id", message.ID, "error", err)
+			continue
+		}
+		entry.Id = message.ID
+		entries = append(entries, entry)
+	}
+
+	if int64(len(entries)) > count {
+		entries = entries[:count]
+	}
+	return entries, nil
+}
@@ -0,0 +1,57 @@
+package calculation
+
+import (
+	"maps"
+	"math"
+	"mmf/internal/audit"
+	"mmf/internal/constants"
+	"mmf/internal/model"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Quality of rejected candidates already audited, the same players are
+// evaluated on every crawler tick so unchanged rejections are recorded once
+var auditedRejections = make(map[string]float64)
+var auditedRejectionsMutex sync.Mutex
+
+// Rejections remembered, all are forgotten once it is reached
+const maxAuditedRejections = 10000
+
+func auditCandidate(queue constants.QueueType, matchId string, candidate Candidate) {
+	playerIds := ticketIds(append(slices.Clone(candidate.Team1), candidate.Team2...))
+	details := maps.Clone(candidate.Details)
+	details["selected"] = candidate.Selected
+	details["team1"] = ticketIds(candidate.Team1)
+	details["team2"] = ticketIds(candidate.Team2)
+
+	if !candidate.Selected {
+		sorted := slices.Clone(playerIds)
+		slices.Sort(sorted)
+		key := queue.String() + ":" + strings.Join(sorted, ",")
+		quality := candidate.Quality
+
+		auditedRejectionsMutex.Lock()
+		previous, ok := auditedRejections[key]
+		if ok && math.Abs(previous-quality) < 0.001 {
+			auditedRejectionsMutex.Unlock()
+			return
+		}
+		if len(auditedRejections) >= maxAuditedRejections {
+			clear(auditedRejections)
+		}
+		auditedRejections[key] = quality
+		auditedRejectionsMutex.Unlock()
+	}
+
+	audit.Record(audit.Entry{Type: audit.CandidateEvaluated, Queue: queue.String(), MatchId: matchId, Players: playerIds, Details: details})
+}
+
+func ticketIds(tickets []model.Ticket) []string {
+	ids := make([]string, 0, len(tickets))
+	for _, ticket := range tickets {
+		ids = append(ids, ticket.Member.Id)
+	}
+	return ids
+}
@@ -1,7 +1,7 @@
 package calculation
 
 import (
-	"log"
+	"log/slog"
 	"math"
 	"mmf/internal/model"
 	"strconv"
@@ -27,7 +27,7 @@ func calculateMatchQualityTrueSkill(tickets1 []model.Ticket, tickets2 []model.Ti
 	for _, ticket := range tickets1 {
 		id, err := strconv.Atoi(ticket.Member.Id)
 		if err != nil {
-			log.Println(err)
+			slog.Warn("Player id is not numeric", "userId", ticket.Member.Id, "error", err)
 		}
 		mu := ticket.Score
 		sigma := mu / 3
@@ -37,7 +37,7 @@ func calculateMatchQualityTrueSkill(tickets1 []model.Ticket, tickets2 []model.Ti
 	for _, ticket := range tickets2 {
 		id, err := strconv.Atoi(ticket.Member.Id)
 		if err != nil {
-			log.Println(err)
+			slog.Warn("Player id is not numeric", "userId", ticket.Member.Id, "error", err)
 		}
 		mu := ticket.Score
 		sigma := mu / 3
@@ -66,7 +66,7 @@ func calculateMatchQualityTrueSkill(tickets1 []model.Ticket, tickets2 []model.Ti
 
 	result, err := game.CalcMatchQuality(teams)
 	if err != nil {
-		log.Println(err)
+		slog.Error("Error calculating match quality", "error", err)
 		return 0.0
 	}
 
@@ -1,43 +1,118 @@
 package calculation
 
 import (
-	"encoding/json"
-	"fmt"
-	"log"
+	"context"
 	"mmf/config"
+	"mmf/internal/clock"
 	"mmf/internal/constants"
+	"mmf/internal/logging"
+	"mmf/internal/matchid"
+	"mmf/internal/metrics"
 	"mmf/internal/model"
-	"mmf/internal/redis"
+	"mmf/internal/tracing"
+	"mmf/internal/wires"
 	"mmf/pkg/client"
 	"mmf/utils"
-	"strconv"
-	"time"
+	"slices"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func EvaluateTickets(config config.MMRConfig, queue constants.QueueType, testData *[]client.TestPairResponse) bool {
-	var tickets []model.Ticket
-	queueZSet := redis.RedisClient.ZRangeWithScores(constants.GetIndexNameQueue(queue), 0, -1)
-	log.Print(queueZSet)
-	if len(queueZSet.Val()) < config.TeamSize*2 {
+// Candidate is a group of tickets evaluated for a match, selected candidates
+// are the matches to start
+type Candidate struct {
+	Team1    []model.Ticket
+	Team2    []model.Ticket
+	Region   constants.Region
+	PoolKey  string
+	Quality  float64
+	Selected bool
+	Details  map[string]interface{} // What decided the candidate, recorded in the audit
+}
+
+func EvaluateTickets(ctx context.Context, config config.MMRConfig, queue constants.QueueType, testData *[]client.TestPairResponse) bool {
+	// Lichess tickets are evaluated per pool, no need to load the whole queue
+	if constants.IsLichessQueue(queue.String()) {
+		return lichessEvaluate(ctx, queue, testData)
+	}
+
+	allTickets := wires.Instance.TicketService.GetAllTickets(queue.String())
+	if allTickets == nil || len(*allTickets) < config.TeamSize*2 {
+		return false
+	}
+
+	// Tickets of players who lost their connection wait for the reaper
+	tickets := wires.Instance.TicketService.FilterLiveTickets(queue.String(), *allTickets)
+	if len(tickets) < config.TeamSize*2 {
 		return false
 	}
 
-	for _, ticket := range queueZSet.Val() {
-		var memberData model.MemberData
-		memberRaw := ticket.Member.(string)
-		if err := json.Unmarshal([]byte(memberRaw), &memberData); err != nil {
-			return false
+	ctx, span := tracing.Start(ctx, "evaluate", attribute.String("queue", queue.String()), attribute.Int("tickets", len(tickets)))
+	defer span.End()
+
+	for _, candidate := range FindMatches(config, queue, tickets, clock.Now().Unix()) {
+		handleCandidate(ctx, queue, candidate, testData)
+	}
+
+	return true
+}
+
+// FindMatches evaluates tickets sorted by score at the given time, without
+// side effects so the evaluator can also run outside of the crawler. Rejected
+// candidates are returned along with the selected ones.
+func FindMatches(config config.MMRConfig, queue constants.QueueType, tickets []model.Ticket, now int64) []Candidate {
+	if constants.IsLichessQueue(queue.String()) {
+		return findLichessMatches(tickets, now)
+	}
+
+	// Tickets are grouped per server region, a ticket can be a candidate in
+	// several regions so the ones already matched are skipped
+	matched := make(map[string]bool)
+	candidates := []Candidate{}
+	for _, region := range constants.GetAllRegions() {
+		regionTickets := getRegionCandidates(tickets, region, config, now)
+		candidates = append(candidates, evaluateRegion(config, queue, region, regionTickets, matched)...)
+	}
+
+	// Players without region preference that weren't matched with anyone having one
+	candidates = append(candidates, evaluateRegion(config, queue, "", getRegionCandidates(tickets, "", config, now), matched)...)
+
+	return candidates
+}
+
+// Starts the match of a selected candidate and audits the candidate, test
+// requests only collect the selected teams
+func handleCandidate(ctx context.Context, queue constants.QueueType, candidate Candidate, testData *[]client.TestPairResponse) {
+	if testData != nil {
+		if candidate.Selected {
+			*testData = append(*testData, client.TestPairResponse{Team1: candidate.Team1, Team2: candidate.Team2})
 		}
+		return
+	}
 
-		// key := fmt.Sprintf("%d_%d_%s", memberData.LichessCustomData.Time, memberData.LichessCustomData.Increment, memberData.LichessCustomData.Collateral)
-		tickets = append(tickets, model.Ticket{Member: memberData, Score: ticket.Score})
+	matchId := ""
+	if candidate.Selected {
+		matchId = matchid.New(queue)
+	}
+	auditCandidate(queue, matchId, candidate)
+	if !candidate.Selected {
+		return
 	}
 
-	if queue == constants.LCQueue || queue == constants.LCQueueTest {
-		return lichessEvaluate(tickets, testData)
+	if err := utils.StartMatch(ctx, matchId, queue, candidate.Region, candidate.PoolKey, candidate.Quality, candidate.Team1, candidate.Team2); err != nil {
+		logging.Match(matchId, queue.String()).Warn("Match not started", "error", err)
+		return
+	}
+	if !constants.IsLichessQueue(queue.String()) {
+		metrics.MatchQuality.WithLabelValues(queue.String()).Observe(candidate.Quality)
 	}
+}
 
+// Sliding window over candidates sorted by score, every window of team size * 2
+// within MMR range is split in teams and matched if the quality is good enough
+func evaluateRegion(config config.MMRConfig, queue constants.QueueType, region constants.Region, tickets []model.Ticket, matched map[string]bool) []Candidate {
 	teamSize := config.TeamSize
+	candidates := []Candidate{}
 
 	for i := 0; i < len(tickets); i++ {
 		// If sliding window is out of bounds, break
@@ -51,19 +126,57 @@ func EvaluateTickets(config config.MMRConfig, queue constants.QueueType, testDat
 		}
 
 		matchTickets := tickets[i : i+config.TeamSize*2]
-		tickets1, tickets2 := getTeams(matchTickets)
-		matchQuality := getMatchQuality(tickets1, tickets2, config.Mode)
-		if matchQuality > config.Treshold {
-			matchId := "match_" + strconv.Itoa(int(time.Now().UnixMilli()))
-			utils.AddMatchToRedis(matchId, tickets1, tickets2, queue)
+		if isAnyMatched(matchTickets, matched) {
+			continue
+		}
+
+		// Players without preference are only placed in a region along with someone who chose it
+		if region != "" && !hasRegionPreference(matchTickets) {
+			continue
+		}
+
+		var tickets1, tickets2 []model.Ticket
+		if queue == constants.D2Queue && teamSize <= 5 {
+			tickets1, tickets2 = getRoleTeams(matchTickets, config.Mode)
+		} else {
+			tickets1, tickets2 = getTeams(matchTickets)
+		}
+		pingPenalty := getPingPenalty(matchTickets, region, config.MaxPing)
+		matchQuality := getMatchQuality(tickets1, tickets2, config.Mode) - pingPenalty
+		selected := matchQuality > config.Treshold
 
-			go utils.WaitingForMatchThread(matchId, queue, tickets1, tickets2)
+		candidates = append(candidates, Candidate{
+			Team1:    tickets1,
+			Team2:    tickets2,
+			Region:   region,
+			Quality:  matchQuality,
+			Selected: selected,
+			Details: map[string]interface{}{
+				"region":      region.String(),
+				"quality":     matchQuality,
+				"pingPenalty": pingPenalty,
+				"threshold":   config.Treshold,
+			},
+		})
 
-			i++
+		if selected {
+			for _, ticket := range matchTickets {
+				matched[ticket.Member.Id] = true
+			}
+			i += teamSize*2 - 1
 		}
 	}
 
-	return true
+	return candidates
+}
+
+func isAnyMatched(tickets []model.Ticket, matched map[string]bool) bool {
+	for _, ticket := range tickets {
+		if matched[ticket.Member.Id] {
+			return true
+		}
+	}
+	return false
 }
 
 func getTeams(tickets []model.Ticket) ([]model.Ticket, []model.Ticket) {
@@ -89,71 +202,120 @@ func getTeams(tickets []model.Ticket) ([]model.Ticket, []model.Ticket) {
 	return tickets1, tickets2
 }
 
-func lichessEvaluate(tickets []model.Ticket, testData *[]client.TestPairResponse) bool {
-	if len(tickets) < 2 {
+func lichessEvaluate(ctx context.Context, queue constants.QueueType, testData *[]client.TestPairResponse) bool {
+	ticketService := wires.Instance.TicketService
+	// A ticket sits in all of its pools, once matched it must be skipped in the rest
+	matched := make(map[string]bool)
+
+	poolKeys := ticketService.GetPoolKeys(queue.String())
+	if len(poolKeys) == 0 {
 		return true
 	}
 
-	ticketsMap := make(map[string][]model.Ticket)
+	ctx, span := tracing.Start(ctx, "evaluate", attribute.String("queue", queue.String()), attribute.Int("pools", len(poolKeys)))
+	defer span.End()
 
-	for i := 0; i < len(tickets); i++ {
-		player := tickets[i]
-		if player.Member.LichessCustomData == nil || len(player.Member.LichessCustomData) == 0 {
+	now := clock.Now().Unix()
+	for _, poolKey := range poolKeys {
+		ticks := ticketService.GetPoolTickets(queue.String(), poolKey)
+		if ticks == nil || len(*ticks) < 2 {
 			continue
 		}
 
-		elapsedTime := time.Now().Unix() - player.Member.LichessCustomData[0].Timestamp
-		checkingValues := 1
-		if elapsedTime > 60 {
-			checkingValues = len(player.Member.LichessCustomData)
+		live := ticketService.FilterLiveTickets(queue.String(), *ticks)
+		for _, candidate := range evaluatePool(poolKey, live, matched, now) {
+			handleCandidate(ctx, queue, candidate, testData)
 		}
+	}
+
+	return true
+}
 
-		for j := 0; j < checkingValues; j++ {
-			key := fmt.Sprintf("%d_%d_%s", player.Member.LichessCustomData[j].Time, player.Member.LichessCustomData[j].Increment, player.Member.LichessCustomData[j].Collateral)
-			ticketsMap[key] = append(ticketsMap[key], player)
+// Splits the tickets in the pools of their game preferences, the same way
+// they are stored in Redis, and pairs players pool by pool
+func findLichessMatches(tickets []model.Ticket, now int64) []Candidate {
+	pools := make(map[string][]model.Ticket)
+	for _, ticket := range tickets {
+		for _, data := range ticket.Member.LichessCustomData {
+			key := data.Key()
+			if !slices.ContainsFunc(pools[key], func(t model.Ticket) bool { return t.Member.Id == ticket.Member.Id }) {
+				pools[key] = append(pools[key], ticket)
+			}
 		}
 	}
 
-	for _, ticks := range ticketsMap {
-		for i := 0; i < len(ticks); i++ {
-			player := ticks[i]
-			difference := getDifference(player.Member.LichessCustomData[0].Timestamp)
+	poolKeys := make([]string, 0, len(pools))
+	for poolKey := range pools {
+		poolKeys = append(poolKeys, poolKey)
+	}
+	slices.Sort(poolKeys)
 
-			for j := i + 1; j < len(ticks); j++ {
-				otherPlayer := ticks[j]
-				otherDifference := getDifference(otherPlayer.Member.LichessCustomData[0].Timestamp)
-				if player.Member.Id == otherPlayer.Member.Id {
-					continue
-				}
+	matched := make(map[string]bool)
+	candidates := []Candidate{}
+	for _, poolKey := range poolKeys {
+		if len(pools[poolKey]) < 2 {
+			continue
+		}
+		candidates = append(candidates, evaluatePool(poolKey, pools[poolKey], matched, now)...)
+	}
+	return candidates
+}
 
-				diff := player.Score - otherPlayer.Score
-				if diff > float64(min(difference, otherDifference)) {
-					if diff > float64(difference) {
-						break
-					}
-					continue
-				}
+// Pairs players of a single pool, tickets are sorted by score so the search
+// for an opponent stops once the difference is out of the player's range
+func evaluatePool(poolKey string, ticks []model.Ticket, matched map[string]bool, now int64) []Candidate {
+	candidates := []Candidate{}
 
-				if testData == nil {
-					matchId := "match_" + strconv.Itoa(int(time.Now().UnixMilli()))
-					utils.AddMatchToRedis(matchId, []model.Ticket{player}, []model.Ticket{otherPlayer}, constants.LCQueue)
+	for i := 0; i < len(ticks); i++ {
+		player := ticks[i]
+		if matched[player.Member.Id] {
+			continue
+		}
+		difference := getDifference(player.Member.LichessCustomData[0].Timestamp, now)
 
-					go utils.WaitingForMatchThread(matchId, constants.LCQueue, []model.Ticket{player}, []model.Ticket{otherPlayer})
-					return true
-				} else {
-					*testData = append(*testData, client.TestPairResponse{Team1: []model.Ticket{player}, Team2: []model.Ticket{otherPlayer}})
-					i++
+		for j := i + 1; j < len(ticks); j++ {
+			otherPlayer := ticks[j]
+			if matched[otherPlayer.Member.Id] || player.Member.Id == otherPlayer.Member.Id {
+				continue
+			}
+			otherDifference := getDifference(otherPlayer.Member.LichessCustomData[0].Timestamp, now)
+
+			diff := otherPlayer.Score - player.Score
+			if diff > float64(min(difference, otherDifference)) {
+				if diff > float64(difference) {
+					break
 				}
+				continue
+			}
 
+			if data1, _ := client.FindMatchingCustomData(player, otherPlayer); data1 == nil {
+				continue
 			}
+
+			matched[player.Member.Id] = true
+			matched[otherPlayer.Member.Id] = true
+
+			candidates = append(candidates, Candidate{
+				Team1:    []model.Ticket{player},
+				Team2:    []model.Ticket{otherPlayer},
+				PoolKey:  poolKey,
+				Selected: true,
+				Details: map[string]interface{}{
+					"poolKey":           poolKey,
+					"ratingDifference":  diff,
+					"allowedDifference": min(difference, otherDifference),
+				},
+			})
+			break
 		}
 	}
 
-	return true
+	return candidates
 }
 
-func getDifference(timestamp int64) int {
-	elapsedTime := time.Now().Unix() - timestamp
+// Rating difference allowed for a player who joined at timestamp, it widens with the wait
+func getDifference(timestamp int64, now int64) int {
+	elapsedTime := now - timestamp
 	difference := 50
 	if elapsedTime > int64(difference) {
 		difference = min(250, int(elapsedTime))
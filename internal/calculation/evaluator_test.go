@@ -0,0 +1,150 @@
+package calculation
+
+import (
+	"strconv"
+	"testing"
+
+	"mmf/config"
+	"mmf/internal/constants"
+	"mmf/internal/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const now = int64(1700000000)
+
+var teamConfig = config.MMRConfig{
+	Mode:            "trueskill",
+	TeamSize:        2,
+	Treshold:        0.1,
+	Range:           200,
+	RegionRelaxTime: 60,
+}
+
+func teamTicket(id int, score float64, regions ...string) model.Ticket {
+	return model.Ticket{
+		Member: model.MemberData{Id: strconv.Itoa(id), Regions: regions, JoinedAt: now},
+		Score:  score,
+	}
+}
+
+func lichessTicket(id int, score float64, joinedAt int64, preferences ...model.LichessCustomData) model.Ticket {
+	for i := range preferences {
+		preferences[i].Timestamp = joinedAt
+	}
+	return model.Ticket{
+		Member: model.MemberData{Id: strconv.Itoa(id), LichessCustomData: preferences, JoinedAt: joinedAt},
+		Score:  score,
+	}
+}
+
+func selected(candidates []Candidate) []Candidate {
+	result := []Candidate{}
+	for _, candidate := range candidates {
+		if candidate.Selected {
+			result = append(result, candidate)
+		}
+	}
+	return result
+}
+
+func playerIds(candidate Candidate) []string {
+	return append(ticketIds(candidate.Team1), ticketIds(candidate.Team2)...)
+}
+
+func TestFindMatchesPairsTicketsWithinRange(t *testing.T) {
+	tickets := []model.Ticket{
+		teamTicket(1, 1000), teamTicket(2, 1010), teamTicket(3, 1020), teamTicket(4, 1030),
+	}
+
+	matches := selected(FindMatches(teamConfig, constants.CS2Queue, tickets, now))
+	if assert.Len(t, matches, 1) {
+		assert.ElementsMatch(t, []string{"1", "2", "3", "4"}, playerIds(matches[0]))
+		assert.Len(t, matches[0].Team1, 2)
+		assert.Len(t, matches[0].Team2, 2)
+		assert.Greater(t, matches[0].Quality, teamConfig.Treshold)
+	}
+}
+
+func TestFindMatchesSkipsWindowsOutOfRange(t *testing.T) {
+	tickets := []model.Ticket{
+		teamTicket(1, 1000), teamTicket(2, 1010), teamTicket(3, 1020), teamTicket(4, 1500),
+	}
+
+	assert.Empty(t, selected(FindMatches(teamConfig, constants.CS2Queue, tickets, now)))
+}
+
+func TestFindMatchesMatchesEachTicketOnce(t *testing.T) {
+	tickets := []model.Ticket{}
+	for i := 1; i <= 10; i++ {
+		tickets = append(tickets, teamTicket(i, 1000+float64(i)))
+	}
+
+	matches := selected(FindMatches(teamConfig, constants.CS2Queue, tickets, now))
+	assert.Len(t, matches, 2)
+
+	seen := map[string]bool{}
+	for _, match := range matches {
+		for _, id := range playerIds(match) {
+			assert.False(t, seen[id], "player %s is in two matches", id)
+			seen[id] = true
+		}
+	}
+}
+
+func TestFindMatchesKeepsRegionsApart(t *testing.T) {
+	euWest, usWest := constants.EUWest.String(), constants.USWest.String()
+	tickets := []model.Ticket{
+		teamTicket(1, 1000, euWest), teamTicket(2, 1010, euWest), teamTicket(3, 1020, usWest), teamTicket(4, 1030, usWest),
+	}
+
+	assert.Empty(t, selected(FindMatches(teamConfig, constants.CS2Queue, tickets, now)))
+}
+
+func TestFindMatchesRelaxesRegionsAfterWaiting(t *testing.T) {
+	euWest, euEast := constants.EUWest.String(), constants.EUEast.String()
+	tickets := []model.Ticket{
+		teamTicket(1, 1000, euWest), teamTicket(2, 1010, euWest), teamTicket(3, 1020, euEast), teamTicket(4, 1030, euEast),
+	}
+
+	assert.Empty(t, selected(FindMatches(teamConfig, constants.CS2Queue, tickets, now)))
+
+	// Neighbouring regions are accepted once the relax time passed
+	matches := selected(FindMatches(teamConfig, constants.CS2Queue, tickets, now+int64(teamConfig.RegionRelaxTime)))
+	assert.Len(t, matches, 1)
+}
+
+func TestFindMatchesPairsLichessPlayersOfTheSamePool(t *testing.T) {
+	blitz := model.LichessCustomData{Time: 3, Increment: 2, Collateral: model.SP, Variant: model.Standard}
+	rapid := model.LichessCustomData{Time: 10, Increment: 0, Collateral: model.SP, Variant: model.Standard}
+	tickets := []model.Ticket{
+		lichessTicket(1, 1500, now, blitz),
+		lichessTicket(2, 1520, now, rapid),
+		lichessTicket(3, 1540, now, blitz),
+	}
+
+	matches := selected(FindMatches(config.MMRConfig{}, constants.LCQueue, tickets, now))
+	if assert.Len(t, matches, 1) {
+		assert.ElementsMatch(t, []string{"1", "3"}, playerIds(matches[0]))
+		assert.Equal(t, blitz.Key(), matches[0].PoolKey)
+	}
+}
+
+func TestFindMatchesWidensLichessRatingDifferenceWithWait(t *testing.T) {
+	blitz := model.LichessCustomData{Time: 3, Increment: 2, Collateral: model.SP, Variant: model.Standard}
+	tickets := []model.Ticket{
+		lichessTicket(1, 1500, now, blitz),
+		lichessTicket(2, 1600, now, blitz),
+	}
+
+	// 50 points are allowed right away, the difference grows with the wait up to 250
+	assert.Empty(t, selected(FindMatches(config.MMRConfig{}, constants.LCQueue, tickets, now)))
+	assert.Len(t, selected(FindMatches(config.MMRConfig{}, constants.LCQueue, tickets, now+100)), 1)
+}
+
+func TestGetDifference(t *testing.T) {
+	assert.Equal(t, 50, getDifference(now, now))
+	assert.Equal(t, 50, getDifference(now, now+50))
+	assert.Equal(t, 120, getDifference(now, now+120))
+	assert.Equal(t, 250, getDifference(now, now+1000))
+}
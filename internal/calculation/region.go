@@ -0,0 +1,65 @@
+package calculation
+
+import (
+	"mmf/config"
+	"mmf/internal/constants"
+	"mmf/internal/model"
+)
+
+// Tickets that can be played in the region - preferred regions right away and
+// their neighbours once the player waited longer than the relax time. Tickets
+// without preference are candidates everywhere, for an empty region only they are.
+func getRegionCandidates(tickets []model.Ticket, region constants.Region, config config.MMRConfig, now int64) []model.Ticket {
+	candidates := make([]model.Ticket, 0, len(tickets))
+	for _, ticket := range tickets {
+		if region == "" {
+			if len(ticket.Member.Regions) == 0 {
+				candidates = append(candidates, ticket)
+			}
+			continue
+		}
+
+		if !ticket.Member.AcceptsRegion(region, config.RegionRelaxTime, now) {
+			continue
+		}
+
+		if ping, ok := ticket.Member.Pings[region.String()]; ok && config.MaxPing > 0 && ping > config.MaxPing {
+			continue
+		}
+
+		candidates = append(candidates, ticket)
+	}
+
+	return candidates
+}
+
+func hasRegionPreference(tickets []model.Ticket) bool {
+	for _, ticket := range tickets {
+		if len(ticket.Member.Regions) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Lowers match quality by up to 0.1 depending on the average reported ping,
+// players that didn't report ping for the region don't affect it
+func getPingPenalty(tickets []model.Ticket, region constants.Region, maxPing int) float64 {
+	if region == "" || maxPing <= 0 {
+		return 0
+	}
+
+	total, reported := 0, 0
+	for _, ticket := range tickets {
+		if ping, ok := ticket.Member.Pings[region.String()]; ok {
+			total += ping
+			reported++
+		}
+	}
+
+	if reported == 0 {
+		return 0
+	}
+
+	return 0.1 * float64(total) / float64(reported*maxPing)
+}
@@ -0,0 +1,118 @@
+package calculation
+
+import (
+	"mmf/internal/model"
+	"slices"
+)
+
+// Weight of role satisfaction against rating balance when picking teams
+const roleWeight = 0.3
+
+// Satisfaction of getting the first, second, third... preferred position
+var rolePreferenceScore = []float64{1.0, 0.75, 0.5, 0.25}
+
+// getRoleTeams tries every split of the tickets in two equal teams and assigns
+// positions 1..n within each team, the split with the best combination of match
+// quality and role satisfaction wins. Returned tickets have their Role set.
+func getRoleTeams(tickets []model.Ticket, mode string) ([]model.Ticket, []model.Ticket) {
+	teamSize := len(tickets) / 2
+	var best1, best2 []model.Ticket
+	bestScore := -1.0
+
+	// First ticket always goes to team 1, otherwise every split is seen twice
+	forEachSplit(len(tickets), teamSize, func(team1Idx []int) {
+		tickets1 := make([]model.Ticket, 0, teamSize)
+		tickets2 := make([]model.Ticket, 0, teamSize)
+		for i, ticket := range tickets {
+			if slices.Contains(team1Idx, i) {
+				tickets1 = append(tickets1, ticket)
+			} else {
+				tickets2 = append(tickets2, ticket)
+			}
+		}
+
+		satisfaction1 := assignRoles(tickets1)
+		satisfaction2 := assignRoles(tickets2)
+		satisfaction := (satisfaction1 + satisfaction2) / float64(len(tickets))
+
+		score := getMatchQuality(tickets1, tickets2, mode)*(1-roleWeight) + satisfaction*roleWeight
+		if score > bestScore {
+			bestScore = score
+			best1, best2 = tickets1, tickets2
+		}
+	})
+
+	return best1, best2
+}
+
+// Calls fn with indexes of team 1 for every way of picking k out of n where
+// index 0 is always picked
+func forEachSplit(n int, k int, fn func([]int)) {
+	picked := []int{0}
+	var pick func(start int)
+	pick = func(start int) {
+		if len(picked) == k {
+			fn(picked)
+			return
+		}
+		for i := start; i < n; i++ {
+			picked = append(picked, i)
+			pick(i + 1)
+			picked = picked[:len(picked)-1]
+		}
+	}
+	pick(1)
+}
+
+// Assigns positions to the team maximising the sum of preference scores,
+// sets Role of every ticket and returns the sum
+func assignRoles(team []model.Ticket) float64 {
+	positions := make([]int, len(team))
+	for i := range positions {
+		positions[i] = i + 1
+	}
+
+	bestScore := -1.0
+	bestPositions := slices.Clone(positions)
+	permute(positions, 0, func(perm []int) {
+		score := 0.0
+		for i, ticket := range team {
+			score += roleScore(ticket.Member.Roles, perm[i])
+		}
+		if score > bestScore {
+			bestScore = score
+			copy(bestPositions, perm)
+		}
+	})
+
+	for i := range team {
+		team[i].Role = bestPositions[i]
+	}
+
+	return bestScore
+}
+
+func permute(values []int, k int, fn func([]int)) {
+	if k == len(values) {
+		fn(values)
+		return
+	}
+	for i := k; i < len(values); i++ {
+		values[k], values[i] = values[i], values[k]
+		permute(values, k+1, fn)
+		values[k], values[i] = values[i], values[k]
+	}
+}
+
+// Players without preferences are happy with any position
+func roleScore(preferences []int, role int) float64 {
+	if len(preferences) == 0 {
+		return 1.0
+	}
+
+	rank := slices.Index(preferences, role)
+	if rank < 0 || rank >= len(rolePreferenceScore) {
+		return 0
+	}
+	return rolePreferenceScore[rank]
+}
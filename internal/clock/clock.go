@@ -0,0 +1,134 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time of matchmaking decisions and drives the crawler and
+// match timeouts. Network deadlines and request durations use real time.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+var current Clock = Real{}
+var currentMutex sync.RWMutex
+
+// Set replaces the clock, tests set a Fake before starting the server
+func Set(clock Clock) {
+	currentMutex.Lock()
+	defer currentMutex.Unlock()
+	current = clock
+}
+
+func get() Clock {
+	currentMutex.RLock()
+	defer currentMutex.RUnlock()
+	return current
+}
+
+func Now() time.Time {
+	return get().Now()
+}
+
+func NewTicker(d time.Duration) Ticker {
+	return get().NewTicker(d)
+}
+
+// Real is the system clock
+type Real struct{}
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+func (Real) NewTicker(d time.Duration) Ticker {
+	return realTicker{ticker: time.NewTicker(d)}
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t realTicker) Stop() {
+	t.ticker.Stop()
+}
+
+// Fake only moves when advanced, so timeouts happen instantly and in order
+type Fake struct {
+	mutex   sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for clock.NewTicker")
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	ticker := &fakeTicker{fake: f, c: make(chan time.Time, 1), period: d, next: f.now.Add(d)}
+	f.tickers = append(f.tickers, ticker)
+	return ticker
+}
+
+// Advance moves the time forward and fires the tickers due by then. Like
+// time.Ticker a tick is dropped when the previous one wasn't received yet.
+func (f *Fake) Advance(d time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.now = f.now.Add(d)
+	for _, ticker := range f.tickers {
+		for !ticker.next.After(f.now) {
+			select {
+			case ticker.c <- ticker.next:
+			default:
+			}
+			ticker.next = ticker.next.Add(ticker.period)
+		}
+	}
+}
+
+type fakeTicker struct {
+	fake   *Fake
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.fake.mutex.Lock()
+	defer t.fake.mutex.Unlock()
+	for i, ticker := range t.fake.tickers {
+		if ticker == t {
+			t.fake.tickers = append(t.fake.tickers[:i], t.fake.tickers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var start = time.Unix(1700000000, 0)
+
+func TestFakeAdvanceMovesTime(t *testing.T) {
+	fake := NewFake(start)
+	assert.Equal(t, start, fake.Now())
+
+	fake.Advance(90 * time.Second)
+	assert.Equal(t, start.Add(90*time.Second), fake.Now())
+}
+
+func TestFakeTickerFiresWhenDue(t *testing.T) {
+	fake := NewFake(start)
+	ticker := fake.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	fake.Advance(9 * time.Second)
+	assertNoTick(t, ticker)
+
+	fake.Advance(1 * time.Second)
+	assert.Equal(t, start.Add(10*time.Second), <-ticker.C())
+	assertNoTick(t, ticker)
+}
+
+func TestFakeTickerDropsMissedTicks(t *testing.T) {
+	fake := NewFake(start)
+	ticker := fake.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	// Like time.Ticker only one tick is buffered, the first one due
+	fake.Advance(35 * time.Second)
+	assert.Equal(t, start.Add(10*time.Second), <-ticker.C())
+	assertNoTick(t, ticker)
+
+	// The schedule isn't shifted by dropped ticks
+	fake.Advance(5 * time.Second)
+	assert.Equal(t, start.Add(40*time.Second), <-ticker.C())
+}
+
+func TestFakeTickerStop(t *testing.T) {
+	fake := NewFake(start)
+	stopped := fake.NewTicker(10 * time.Second)
+	running := fake.NewTicker(10 * time.Second)
+	defer running.Stop()
+
+	stopped.Stop()
+	fake.Advance(10 * time.Second)
+	assertNoTick(t, stopped)
+	assert.Equal(t, start.Add(10*time.Second), <-running.C())
+}
+
+func TestFakeTickerRejectsNonPositiveInterval(t *testing.T) {
+	assert.Panics(t, func() { NewFake(start).NewTicker(0) })
+}
+
+func TestSetReplacesClock(t *testing.T) {
+	defer Set(Real{})
+
+	fake := NewFake(start)
+	Set(fake)
+	assert.Equal(t, start, Now())
+	fake.Advance(time.Minute)
+	assert.Equal(t, start.Add(time.Minute), Now())
+}
+
+func assertNoTick(t *testing.T, ticker Ticker) {
+	t.Helper()
+	select {
+	case tick := <-ticker.C():
+		t.Fatalf("unexpected tick at %s", tick)
+	default:
+	}
+}
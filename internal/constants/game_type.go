@@ -19,3 +19,8 @@ func GetAllGameTypes() []GameType {
 func GetIndexName(game GameType) string {
 	return "players_" + game.String()
 }
+
+// Hash holding the state and pending ban of a map veto
+func GetVetoKey(matchId string) string {
+	return "veto_" + matchId
+}
@@ -39,3 +39,40 @@ func GetIndexNameQueue(queue QueueType) string {
 func GetIndexNameStr(queue string) string {
 	return "players_" + queue
 }
+
+func IsLichessQueue(queue string) bool {
+	return queue == string(LCQueue) || queue == string(LCQueueTest)
+}
+
+// Dota 2 queues need a lobby template
+func IsDota2Queue(queue string) bool {
+	return queue == string(D2Queue)
+}
+
+// Sorted set holding tickets of the queue that accept the given pool key
+func GetPoolIndexName(queue string, poolKey string) string {
+	return "pool_" + queue + "_" + poolKey
+}
+
+// Hash of the attributes of a ticket, one field per attribute
+func GetTicketKey(ticketId string) string {
+	return "ticket_" + ticketId
+}
+
+// Hash of the ticket id of every user in the queue
+func GetTicketIndexName(queue string) string {
+	return "tickets_" + queue
+}
+
+// Set of all pool indexes created for the queue
+func GetPoolSetName(queue string) string {
+	return "pools_" + queue
+}
+
+// Heartbeats of the tickets of the queue, user ids scored by expiry time
+func GetHeartbeatSetName(queue string) string {
+	return "heartbeats_" + queue
+}
+
+// Set of queues where the crawler doesn't create matches
+const PausedQueuesSet = "paused_queues"
@@ -0,0 +1,72 @@
+package constants
+
+type Region string
+
+const (
+	EUWest Region = "eu_west"
+	EUEast Region = "eu_east"
+	USEast Region = "us_east"
+	USWest Region = "us_west"
+	SEA    Region = "sea"
+	SA     Region = "sa"
+)
+
+var RegionValue = map[string]Region{
+	"eu_west": EUWest,
+	"eu_east": EUEast,
+	"us_east": USEast,
+	"us_west": USWest,
+	"sea":     SEA,
+	"sa":      SA,
+}
+
+// Regions players get relaxed to after waiting in the queue for too long
+var regionNeighbours = map[Region][]Region{
+	EUWest: {EUEast, USEast},
+	EUEast: {EUWest},
+	USEast: {USWest, EUWest, SA},
+	USWest: {USEast, SEA},
+	SEA:    {USWest},
+	SA:     {USEast},
+}
+
+// Dota 2 lobby server region ids
+var dota2ServerRegions = map[Region]int{
+	EUWest: 3,
+	EUEast: 9,
+	USEast: 2,
+	USWest: 1,
+	SEA:    5,
+	SA:     10,
+}
+
+// DatHost server locations
+var cs2Locations = map[Region]string{
+	EUWest: "amsterdam",
+	EUEast: "frankfurt",
+	USEast: "new_york_city",
+	USWest: "los_angeles",
+	SEA:    "singapore",
+	SA:     "sao_paulo",
+}
+
+func (r Region) String() string {
+	return string(r)
+}
+
+func GetAllRegions() []Region {
+	return []Region{EUWest, EUEast, USEast, USWest, SEA, SA}
+}
+
+func GetRegionNeighbours(region Region) []Region {
+	return regionNeighbours[region]
+}
+
+func GetDota2ServerRegion(region Region) (int, bool) {
+	serverRegion, ok := dota2ServerRegions[region]
+	return serverRegion, ok
+}
+
+func GetCS2Location(region Region) string {
+	return cs2Locations[region]
+}
@@ -0,0 +1,75 @@
+package events
+
+import (
+	"log/slog"
+	"mmf/internal/clock"
+	"sync"
+)
+
+type MatchEventType string
+
+const (
+	MatchFound     MatchEventType = "MATCH_FOUND"
+	PlayerReplaced MatchEventType = "PLAYER_REPLACED"
+	MatchAccepted  MatchEventType = "MATCH_ACCEPTED"
+	PaymentPending MatchEventType = "PAYMENT_PENDING"
+	MatchScheduled MatchEventType = "MATCH_SCHEDULED"
+	MatchCancelled MatchEventType = "MATCH_CANCELLED"
+)
+
+// MatchEvent is a step of the match lifecycle
+type MatchEvent struct {
+	Type      MatchEventType `json:"type"`
+	MatchId   string         `json:"matchId"`
+	Queue     string         `json:"queue"`
+	Region    string         `json:"region,omitempty"`
+	Players   []string       `json:"players"`
+	Reason    string         `json:"reason,omitempty"`
+	Timestamp int64          `json:"timestamp"`
+}
+
+// Events buffered per subscriber before new ones are dropped for it
+const subscriberBufferSize = 256
+
+var subscribers = make(map[chan MatchEvent]struct{})
+var subscribersMutex sync.Mutex
+
+// PublishMatchEvent delivers the event to every subscriber, it never blocks
+// the match thread so slow subscribers miss events instead
+func PublishMatchEvent(event MatchEvent) {
+	if event.Timestamp == 0 {
+		event.Timestamp = clock.Now().Unix()
+	}
+
+	subscribersMutex.Lock()
+	defer subscribersMutex.Unlock()
+
+	for subscriber := range subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			slog.Warn("Match event subscriber is full, dropping event", "eventType", event.Type, "matchId", event.MatchId)
+		}
+	}
+}
+
+// SubscribeMatchEvents returns a channel of all match events published from
+// now on, unsubscribe closes the channel
+func SubscribeMatchEvents() (<-chan MatchEvent, func()) {
+	subscriber := make(chan MatchEvent, subscriberBufferSize)
+
+	subscribersMutex.Lock()
+	subscribers[subscriber] = struct{}{}
+	subscribersMutex.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			subscribersMutex.Lock()
+			delete(subscribers, subscriber)
+			subscribersMutex.Unlock()
+			close(subscriber)
+		})
+	}
+	return subscriber, unsubscribe
+}
@@ -0,0 +1,195 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"mmf/config"
+	"mmf/internal/clock"
+	"mmf/internal/redis"
+
+	goredis "github.com/go-redis/redis"
+)
+
+type Outcome string
+
+const (
+	InProgress Outcome = "in_progress"
+	Scheduled  Outcome = "scheduled"
+	Cancelled  Outcome = "cancelled"
+)
+
+type Player struct {
+	Id       string  `json:"id"`
+	Team     int     `json:"team"`
+	Elo      float64 `json:"elo"` // Rating at match time
+	Role     int     `json:"role,omitempty"`
+	Accepted bool    `json:"accepted"`
+	Paid     bool    `json:"paid"`
+	TxHash   string  `json:"txHash,omitempty"`
+	Stake    string  `json:"stake,omitempty"`    // Amount paid on chain in base units of the collateral token
+	Replaced bool    `json:"replaced,omitempty"` // Declined and was replaced by another player
+}
+
+// Match is a created match, kept once it is scheduled or cancelled
+type Match struct {
+	MatchId   string   `json:"matchId"`
+	Queue     string   `json:"queue"`
+	Region    string   `json:"region,omitempty"`
+	Players   []Player `json:"players"`
+	Quality   float64  `json:"quality,omitempty"`
+	Stake     string   `json:"stake,omitempty"` // Collateral token of quickplay matches, players hold the amounts
+	Outcome   Outcome  `json:"outcome"`
+	Reason    string   `json:"reason,omitempty"`
+	CreatedAt int64    `json:"createdAt"`
+	EndedAt   int64    `json:"endedAt,omitempty"`
+}
+
+func matchKey(matchId string) string {
+	return "match_history_" + matchId
+}
+
+// Result reported by the game server, kept for the result retention
+func resultKey(matchId string) string {
+	return "match_result_" + matchId
+}
+
+// Hash of the results stored before they had a retention, read until they are all gone
+const legacyResults = "match_results"
+
+// Matches of the user scored by creation time
+func userKey(userId string) string {
+	return "match_history_user_" + userId
+}
+
+// Save stores the match and lists it in the history of each of its players,
+// matches are kept for the history retention after their creation
+func Save(match *Match) error {
+	data, err := json.Marshal(match)
+	if err != nil {
+		return err
+	}
+
+	retention := time.Duration(config.GlobalConfig.History.RetentionDays) * 24 * time.Hour
+	expiry := time.Unix(match.CreatedAt, 0).Add(retention).Sub(clock.Now())
+	if expiry <= 0 {
+		return nil
+	}
+	cutoff := strconv.FormatInt(clock.Now().Add(-retention).Unix(), 10)
+
+	pipe := redis.RedisClient.TxPipeline()
+	pipe.Set(matchKey(match.MatchId), data, expiry)
+	for _, player := range match.Players {
+		pipe.ZAdd(userKey(player.Id), goredis.Z{Score: float64(match.CreatedAt), Member: match.MatchId})
+		pipe.ZRemRangeByScore(userKey(player.Id), "-inf", "("+cutoff)
+		pipe.Expire(userKey(player.Id), retention)
+	}
+	_, err = pipe.Exec()
+	return err
+}
+
+// Complete records the outcome of the match and the final responses of its
+// players, players missing from them were replaced
+func Complete(matchId string, outcome Outcome, reason string, players []Player) error {
+	match, err := GetMatch(matchId)
+	if err != nil {
+		return err
+	}
+	if match == nil {
+		return fmt.Errorf("match %s not found in history", matchId)
+	}
+
+	final := make(map[string]bool, len(players))
+	for _, player := range players {
+		final[player.Id] = true
+	}
+	for _, player := range match.Players {
+		if !final[player.Id] {
+			player.Accepted = false
+			player.Replaced = true
+			players = append(players, player)
+		}
+	}
+
+	match.Players = players
+	match.Outcome = outcome
+	match.Reason = reason
+	match.EndedAt = clock.Now().Unix()
+	return Save(match)
+}
+
+// GetMatch returns the match or nil if it is not in the history
+func GetMatch(matchId string) (*Match, error) {
+	data, err := redis.RedisClient.Get(matchKey(matchId)).Result()
+	if err == goredis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var match Match
+	if err := json.Unmarshal([]byte(data), &match); err != nil {
+		return nil, err
+	}
+	return &match, nil
+}
+
+// SaveResult stores the result the game server reported for the match
+func SaveResult(matchId string, result []byte, retention time.Duration) error {
+	return redis.RedisClient.Set(resultKey(matchId), result, retention).Err()
+}
+
+// GetResult returns the result of the match, nil until one is reported or
+// once it expired
+func GetResult(matchId string) ([]byte, error) {
+	result, err := redis.RedisClient.Get(resultKey(matchId)).Bytes()
+	if err == goredis.Nil {
+		result, err = redis.RedisClient.HGet(legacyResults, matchId).Bytes()
+	}
+	if err == goredis.Nil {
+		return nil, nil
+	}
+	return result, err
+}
+
+// UserMatches returns a page of the user's matches newest first, and the number of matches
+func UserMatches(userId string, offset int64, limit int64) ([]Match, int64, error) {
+	total, err := redis.RedisClient.ZCard(userKey(userId)).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	matchIds, err := redis.RedisClient.ZRevRange(userKey(userId), offset, offset+limit-1).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(matchIds) == 0 {
+		return []Match{}, total, nil
+	}
+
+	keys := make([]string, 0, len(matchIds))
+	for _, matchId := range matchIds {
+		keys = append(keys, matchKey(matchId))
+	}
+	values, err := redis.RedisClient.MGet(keys...).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	matches := make([]Match, 0, len(values))
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var match Match
+		if err := json.Unmarshal([]byte(data), &match); err != nil {
+			return nil, 0, err
+		}
+		matches = append(matches, match)
+	}
+	return matches, total, nil
+}
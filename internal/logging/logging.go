@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+
+	"mmf/config"
+)
+
+const redacted = "[REDACTED]"
+
+// Attribute keys holding credentials, matched case insensitively on the key
+var secretKeys = []string{"apikey", "api_key", "token", "password", "secret", "authorization", "passkey"}
+
+// Init installs the default logger, the standard log package is routed
+// through it as well
+func Init(logConfig config.LogConfig) {
+	options := &slog.HandlerOptions{
+		Level:       parseLevel(logConfig.Level),
+		ReplaceAttr: redactSecrets,
+	}
+
+	var handler slog.Handler
+	if logConfig.Format == "json" {
+		handler = slog.NewJSONHandler(os.Stdout, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stdout, options)
+	}
+
+	slog.SetDefault(slog.New(handler))
+}
+
+func parseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+func redactSecrets(groups []string, attr slog.Attr) slog.Attr {
+	key := strings.ToLower(attr.Key)
+	for _, secretKey := range secretKeys {
+		if strings.Contains(key, secretKey) {
+			return slog.String(attr.Key, redacted)
+		}
+	}
+	return attr
+}
+
+// Match returns a logger for a match lifecycle
+func Match(matchId string, queue string) *slog.Logger {
+	return slog.With("matchId", matchId, "queue", queue)
+}
+
+// User returns a logger for requests of a user on a queue
+func User(userId string, queue string) *slog.Logger {
+	return slog.With("userId", userId, "queue", queue)
+}
+
+type loggerKey struct{}
+
+// WithLogger stores the logger in the context, e.g. one carrying the request id
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger of the context or the default one
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,41 @@
+package matchid
+
+import (
+	"regexp"
+	"strings"
+
+	"mmf/internal/constants"
+
+	"github.com/google/uuid"
+)
+
+// Ids of matches created before queue prefixed ids, accepted until they are all over
+var legacyId = regexp.MustCompile(`^match_[0-9]+$`)
+
+// New returns the id of a match of the queue, the queue followed by a UUIDv7.
+// Ids are unique across queues and replicas and sort by creation time, they
+// are also the key of the match in Redis and the id of the match on chain.
+func New(queue constants.QueueType) string {
+	return string(queue) + "_" + uuid.Must(uuid.NewV7()).String()
+}
+
+// Valid returns true for ids made by New, anything else must not be used as a key
+func Valid(id string) bool {
+	if legacyId.MatchString(id) {
+		return true
+	}
+
+	for _, queue := range append(constants.GetAllQueueTypes(), constants.LCQueueTest) {
+		rest, ok := strings.CutPrefix(id, string(queue)+"_")
+		if !ok {
+			continue
+		}
+
+		// Only the canonical form, uuid.Parse also accepts braces and urn prefixes
+		parsed, err := uuid.Parse(rest)
+		if err == nil && parsed.Version() == 7 && parsed.String() == rest {
+			return true
+		}
+	}
+	return false
+}
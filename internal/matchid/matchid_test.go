@@ -0,0 +1,59 @@
+package matchid
+
+import (
+	"testing"
+
+	"mmf/internal/constants"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const uuidV7 = "0190b3c4-5d6e-7f80-9a1b-2c3d4e5f6a7b"
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		name  string
+		id    string
+		valid bool
+	}{
+		{"legacy", "match_1700000000123", true},
+		{"legacy without number", "match_", false},
+		{"legacy with letters", "match_12ab", false},
+		{"cs2 queue", "cs2queue_" + uuidV7, true},
+		{"d2 queue", "d2queue_" + uuidV7, true},
+		{"lichess queue", "lcqueue_" + uuidV7, true},
+		{"lichess test queue", "lcqueue_test_" + uuidV7, true},
+		{"v4 uuid", "cs2queue_f47ac10b-58cc-4372-a567-0e02b2c3d479", false},
+		{"uppercase uuid", "cs2queue_0190B3C4-5D6E-7F80-9A1B-2C3D4E5F6A7B", false},
+		{"braces", "cs2queue_{" + uuidV7 + "}", false},
+		{"urn", "cs2queue_urn:uuid:" + uuidV7, false},
+		{"no dashes", "cs2queue_0190b3c45d6e7f809a1b2c3d4e5f6a7b", false},
+		{"unknown prefix", "chessqueue_" + uuidV7, false},
+		{"no prefix", uuidV7, false},
+		{"key of another hash", "user_state", false},
+		{"empty", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.valid, Valid(test.id))
+		})
+	}
+}
+
+func TestNewIsValid(t *testing.T) {
+	for _, queue := range append(constants.GetAllQueueTypes(), constants.LCQueueTest) {
+		id := New(queue)
+		assert.True(t, Valid(id), "id %s of queue %s should be valid", id, queue)
+	}
+}
+
+func TestNewIsUniqueWithinATick(t *testing.T) {
+	// Every pool evaluated in a tick creates its matches within the same millisecond
+	ids := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		id := New(constants.LCQueue)
+		assert.False(t, ids[id], "id %s was generated twice", id)
+		ids[id] = true
+	}
+}
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// Responses of players to a found match
+const (
+	ResponseAccept  = "accept"
+	ResponseDecline = "decline"
+	ResponseTimeout = "timeout"
+)
+
+var (
+	QueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mmf_queue_depth",
+		Help: "Players waiting in queue at the last crawler tick",
+	}, []string{"queue"})
+
+	TimeToMatch = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mmf_time_to_match_seconds",
+		Help:    "Time players waited in queue before being matched",
+		Buckets: []float64{5, 10, 20, 30, 60, 90, 120, 180, 300, 600, 1200},
+	}, []string{"queue"})
+
+	MatchQuality = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mmf_match_quality",
+		Help:    "Quality of created team matches, 1 is a perfect match",
+		Buckets: prometheus.LinearBuckets(0.5, 0.05, 11),
+	}, []string{"queue"})
+
+	MatchesCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mmf_matches_created_total",
+		Help: "Matches created by the evaluator",
+	}, []string{"queue"})
+
+	MatchResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mmf_match_responses_total",
+		Help: "Player responses to found matches - accept, decline or timeout",
+	}, []string{"queue", "response"})
+
+	PaymentVerification = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mmf_payment_verification_seconds",
+		Help:    "Time to verify a payment transaction on chain",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"queue", "result"})
+
+	OutboundRequests = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mmf_outbound_request_duration_seconds",
+		Help:    "Latency of calls to external services, status is error when no response was received",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"integration", "status"})
+
+	WebsocketConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mmf_websocket_connections",
+		Help: "Open websocket connections",
+	}, []string{"queue"})
+
+	TicketsExpired = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mmf_tickets_expired_total",
+		Help: "Tickets removed because their owner stopped sending heartbeats",
+	}, []string{"queue"})
+)
+
+// ObservePaymentVerification records how long verifying a payment took
+func ObservePaymentVerification(queue string, start time.Time, ok bool) {
+	result := "ok"
+	if !ok {
+		result = "failed"
+	}
+	PaymentVerification.WithLabelValues(queue, result).Observe(time.Since(start).Seconds())
+}
+
+// HTTPClient returns a client recording latency and status of calls to the integration,
+// calls are traced and carry the trace context of the request
+func HTTPClient(integration string) *http.Client {
+	traced := otelhttp.NewTransport(http.DefaultTransport, otelhttp.WithSpanNameFormatter(func(_ string, req *http.Request) string {
+		return integration + " " + req.Method
+	}))
+	return &http.Client{Transport: &instrumentedTransport{integration: integration, next: traced}}
+}
+
+type instrumentedTransport struct {
+	integration string
+	next        http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	OutboundRequests.WithLabelValues(t.integration, status).Observe(time.Since(start).Seconds())
+
+	return resp, err
+}
@@ -2,7 +2,7 @@ package model
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 )
 
 type EloData struct {
@@ -15,16 +15,23 @@ type MatchPlayer struct {
 	Team              int     `json:"team"`
 	Score             float64 `json:"score"`
 	TxnHash           string  `json:"txnHash"`
+	Amount            string  `json:"amount,omitempty"` // Paid on chain, base units of the collateral token
 	Paid              bool    `json:"paid"`
 	ApiKey            string
 	WalletAddress     string              `json:"walletAddress"`
 	LichessCustomData []LichessCustomData `json:"lichessCustomData"`
+	Regions           []string            `json:"regions,omitempty"`
+	Pings             map[string]int      `json:"pings,omitempty"`
+	Roles             []int               `json:"roles,omitempty"`
+	Role              int                 `json:"role,omitempty"`
+	NoExpiry          bool                `json:"noExpiry,omitempty"`
+	JoinedAt          int64               `json:"joinedAt,omitempty"` // Of the ticket, kept when requeued
 }
 
 func (mp *MatchPlayer) Marshal() []byte {
 	marshalled, err := json.Marshal(mp)
 	if err != nil {
-		log.Println(err)
+		slog.Error("Error marshalling match player", "error", err)
 		return nil
 	}
 
@@ -35,7 +42,7 @@ func UnmarshalMatchPlayer(data []byte) *MatchPlayer {
 	var mp MatchPlayer
 	err := json.Unmarshal(data, &mp)
 	if err != nil {
-		log.Println(err)
+		slog.Error("Error unmarshalling match player", "error", err)
 		return nil
 	}
 
@@ -71,7 +78,7 @@ type UserGlobalState struct {
 func (ugs *UserGlobalState) Marshal() []byte {
 	marshalled, err := json.Marshal(ugs)
 	if err != nil {
-		log.Println(err)
+		slog.Error("Error marshalling user state", "error", err)
 		return nil
 	}
 
@@ -82,9 +89,49 @@ func UnmarshalUserGlobalState(data []byte) *UserGlobalState {
 	var ugs UserGlobalState
 	err := json.Unmarshal(data, &ugs)
 	if err != nil {
-		log.Println(err)
+		slog.Error("Error unmarshalling user state", "error", err)
 		return nil
 	}
 
 	return &ugs
 }
+
+type MapVeto struct {
+	MatchId    string   `json:"matchId"`
+	Captains   []string `json:"captains"` // Captain of team 1 and team 2
+	Remaining  []string `json:"remaining"`
+	Banned     []string `json:"banned"`
+	Turn       int      `json:"turn"`  // Team whose captain bans next
+	Round      int      `json:"round"` // Number of the ban, a ban is only taken in its round
+	ExpiryTime int64    `json:"expiryTime"`
+	Map        string   `json:"map,omitempty"` // Set once a single map remains
+}
+
+func (mv *MapVeto) Marshal() []byte {
+	marshalled, err := json.Marshal(mv)
+	if err != nil {
+		slog.Error("Error marshalling map veto", "error", err)
+		return nil
+	}
+
+	return marshalled
+}
+
+func UnmarshalMapVeto(data []byte) *MapVeto {
+	var mv MapVeto
+	err := json.Unmarshal(data, &mv)
+	if err != nil {
+		slog.Error("Error unmarshalling map veto", "error", err)
+		return nil
+	}
+
+	return &mv
+}
+
+// CurrentCaptain returns id of the player allowed to ban next
+func (mv *MapVeto) CurrentCaptain() string {
+	if mv.Turn < 1 || mv.Turn > len(mv.Captains) {
+		return ""
+	}
+	return mv.Captains[mv.Turn-1]
+}
@@ -1,7 +1,9 @@
 package model
 
 import (
-	"encoding/json"
+	"fmt"
+	"mmf/internal/constants"
+	"slices"
 )
 
 type SubmitTicketRequest struct {
@@ -9,17 +11,30 @@ type SubmitTicketRequest struct {
 	Elo               float64             `json:"elo"`
 	WalletAddress     string              `json:"walletAddress"`
 	LichessCustomData []LichessCustomData `json:"lichessCustomData"`
+	Regions           []string            `json:"regions"`
+	Pings             map[string]int      `json:"pings"`
+	Roles             []int               `json:"roles"`
+	NoExpiry          bool                `json:"-"` // Kept without heartbeats, set for tickets of backend services
+	JoinedAt          int64               `json:"-"` // Kept when players are put back in the queue, now when zero
 }
 
 type Ticket struct {
 	Member MemberData `json:"member"`
 	Score  float64    `json:"score"`
+	Role   int        `json:"role,omitempty"` // Position assigned by the team builder
 }
 
 type MemberData struct {
+	TicketId          string              `json:"ticketId,omitempty"` // Member of the queue and pool sorted sets
 	Id                string              `json:"id"`
 	WalletAddress     string              `json:"walletAddress"`
 	LichessCustomData []LichessCustomData `json:"lichessCustomData"`
+	Regions           []string            `json:"regions,omitempty"` // Preferred server regions
+	Pings             map[string]int      `json:"pings,omitempty"`   // Measured ping in ms per region
+	Roles             []int               `json:"roles,omitempty"`   // Positions ranked by preference
+	JoinedAt          int64               `json:"joinedAt,omitempty"`
+	TraceParent       string              `json:"traceParent,omitempty"` // Trace context of the submission, linked from the match
+	NoExpiry          bool                `json:"noExpiry,omitempty"`    // Submitted by a backend service, doesn't expire without heartbeats
 }
 
 type Collateral string
@@ -30,17 +45,115 @@ const (
 	USDT Collateral = "USDT"
 )
 
+type Variant string
+
+const (
+	Standard      Variant = "standard"
+	Chess960      Variant = "chess960"
+	Crazyhouse    Variant = "crazyhouse"
+	Antichess     Variant = "antichess"
+	Atomic        Variant = "atomic"
+	Horde         Variant = "horde"
+	KingOfTheHill Variant = "kingOfTheHill"
+	RacingKings   Variant = "racingKings"
+	ThreeCheck    Variant = "threeCheck"
+	FromPosition  Variant = "fromPosition"
+)
+
+var VariantValue = map[string]Variant{
+	"standard":      Standard,
+	"chess960":      Chess960,
+	"crazyhouse":    Crazyhouse,
+	"antichess":     Antichess,
+	"atomic":        Atomic,
+	"horde":         Horde,
+	"kingOfTheHill": KingOfTheHill,
+	"racingKings":   RacingKings,
+	"threeCheck":    ThreeCheck,
+	"fromPosition":  FromPosition,
+}
+
+type Color string
+
+const (
+	White  Color = "white"
+	Black  Color = "black"
+	Random Color = "random"
+)
+
+var ColorValue = map[string]Color{
+	"white":  White,
+	"black":  Black,
+	"random": Random,
+}
+
 type LichessCustomData struct {
 	Time       int        `json:"time"`
 	Increment  int        `json:"increment"`
 	Collateral Collateral `json:"collateral"`
+	Variant    Variant    `json:"variant"`
+	Rated      bool       `json:"rated"`
+	Color      Color      `json:"color"`
 	Timestamp  int64      `json:"timestamp"`
 }
 
-func (md *MemberData) MarshalBinary() ([]byte, error) {
-	return json.Marshal(md)
+// Key identifies the pool a preference belongs to, players can only be paired
+// when their keys are equal
+func (lcd *LichessCustomData) Key() string {
+	variant := lcd.Variant
+	if variant == "" {
+		variant = Standard
+	}
+	return fmt.Sprintf("%d_%d_%s_%s_%t", lcd.Time, lcd.Increment, lcd.Collateral, variant, lcd.Rated)
+}
+
+// PerfType returns the lichess perf the preference is rated in - the variant
+// itself for non standard games, otherwise the speed derived from the clock
+func (lcd *LichessCustomData) PerfType() string {
+	if lcd.Variant != "" && lcd.Variant != Standard && lcd.Variant != FromPosition {
+		return string(lcd.Variant)
+	}
+
+	// lichess estimates game duration as limit + 40 * increment
+	estimated := lcd.Time*60 + 40*lcd.Increment
+	switch {
+	case estimated < 30:
+		return "ultraBullet"
+	case estimated < 180:
+		return "bullet"
+	case estimated < 480:
+		return "blitz"
+	case estimated < 1500:
+		return "rapid"
+	default:
+		return "classical"
+	}
+}
+
+// ColorsCompatible returns false only when both players insist on the same color
+func ColorsCompatible(color1, color2 Color) bool {
+	if color1 == "" || color1 == Random || color2 == "" || color2 == Random {
+		return true
+	}
+	return color1 != color2
 }
 
-func (md *MemberData) UnmarshalBinary(data []byte) error {
-	return json.Unmarshal(data, md)
+// AcceptsRegion returns true for preferred regions right away and for their
+// neighbours once the player waited longer than the relax time
+func (md *MemberData) AcceptsRegion(region constants.Region, relaxTime int, now int64) bool {
+	if len(md.Regions) == 0 || slices.Contains(md.Regions, region.String()) {
+		return true
+	}
+
+	if now-md.JoinedAt < int64(relaxTime) {
+		return false
+	}
+
+	for _, preferred := range md.Regions {
+		if slices.Contains(constants.GetRegionNeighbours(constants.Region(preferred)), region) {
+			return true
+		}
+	}
+
+	return false
 }
@@ -1,14 +1,28 @@
 package crawler
 
 import (
+	"context"
+	"log/slog"
 	"mmf/config"
 	"mmf/internal/calculation"
+	"mmf/internal/clock"
 	"mmf/internal/constants"
+	"mmf/internal/stats"
+	"mmf/internal/wires"
+	"mmf/utils"
 )
 
 func StartCrawler(config config.MMRConfig) bool {
 	for _, queue := range constants.GetAllQueueTypes() {
-		calculation.EvaluateTickets(config, queue, nil)
+		if _, err := utils.ReapExpiredTickets(queue.String()); err != nil {
+			slog.Error("Error removing expired tickets", "queue", queue.String(), "error", err)
+		}
+		if !wires.Instance.TicketService.IsQueuePaused(queue.String()) {
+			calculation.EvaluateTickets(context.Background(), config, queue, nil)
+		}
+		if _, err := stats.Update(config, queue.String(), clock.Now().Unix()); err != nil {
+			slog.Error("Error updating queue stats", "queue", queue.String(), "error", err)
+		}
 	}
 	return true
 }
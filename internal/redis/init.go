@@ -2,8 +2,9 @@ package redis
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"mmf/config"
+	"os"
 
 	"github.com/go-redis/redis"
 )
@@ -18,7 +19,8 @@ func Init(config *config.Config, ctx context.Context) {
 
 	_, err := RedisClient.Ping().Result()
 	if err != nil {
-		log.Fatalf("Could not connect to Redis: %s", err)
+		slog.Error("Could not connect to Redis", "error", err)
+		os.Exit(1)
 	}
-	log.Println("Connected to Redis")
+	slog.Info("Connected to Redis")
 }
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"strconv"
+	"strings"
+
+	"mmf/config"
+	"mmf/internal/audit"
+	"mmf/internal/constants"
+	"mmf/internal/logging"
+	ws "mmf/internal/server/websockets"
+	"mmf/internal/wires"
+	"mmf/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Operator endpoints, authenticated with "Authorization: Bearer <ADMIN_API_KEY>"
+func RegisterAdmin(router *gin.Engine, ctx context.Context) {
+	admin := router.Group("/admin", adminAuth(config.GlobalConfig.Server.AdminApiKey))
+	{
+		queues := admin.Group("/queues/:queue", adminQueue)
+		{
+			queues.GET("/tickets", adminListTickets)
+			queues.GET("/tickets/:userId", adminGetTicket)
+			queues.DELETE("/tickets", adminFlushQueue)
+			queues.POST("/pause", adminPauseQueue)
+			queues.POST("/resume", adminResumeQueue)
+			queues.POST("/matches", adminCreateMatch)
+		}
+
+		admin.GET("/matches", adminListMatches)
+		admin.GET("/matches/:matchId", adminGetMatch)
+		admin.POST("/matches/:matchId/cancel", adminCancelMatch)
+		admin.DELETE("/users/:userId/connections", adminKickUser)
+		admin.GET("/audit", adminAudit)
+	}
+}
+
+func adminAuth(apiKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if apiKey == "" {
+			c.AbortWithStatusJSON(403, gin.H{"error": "admin api is disabled"})
+			return
+		}
+
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(apiKey)) != 1 {
+			c.AbortWithStatusJSON(401, gin.H{"error": "invalid api key"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func adminQueue(c *gin.Context) {
+	if constants.GetQueueType(c.Param("queue")) == "" {
+		c.AbortWithStatusJSON(400, gin.H{"error": "unknown queue"})
+		return
+	}
+	c.Next()
+}
+
+func adminListTickets(c *gin.Context) {
+	queue := c.Param("queue")
+	tickets := wires.Instance.TicketService.GetAllTickets(queue)
+	if tickets == nil {
+		c.JSON(500, gin.H{"error": "error fetching tickets"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"queue":   queue,
+		"paused":  wires.Instance.TicketService.IsQueuePaused(queue),
+		"tickets": tickets,
+	})
+}
+
+func adminGetTicket(c *gin.Context) {
+	userId := c.Param("userId")
+	ticket := wires.Instance.TicketService.GetTicket(c.Param("queue"), userId)
+	if ticket == nil {
+		c.JSON(404, gin.H{"error": "ticket not found"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"ticket":    ticket,
+		"userState": ws.GetUserState(userId),
+		"connected": ws.IsConnected(userId),
+	})
+}
+
+func adminFlushQueue(c *gin.Context) {
+	queue := c.Param("queue")
+	removed, err := utils.FlushQueue(queue)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Error flushing queue", "queue", queue, "error", err)
+		c.JSON(500, gin.H{"error": "error flushing queue"})
+		return
+	}
+
+	recordAdminAction(c, "flush_queue", audit.Entry{Queue: queue, Details: map[string]interface{}{"removed": removed}})
+	c.JSON(200, gin.H{"removed": removed})
+}
+
+func adminPauseQueue(c *gin.Context) {
+	if err := wires.Instance.TicketService.PauseQueue(c.Param("queue")); err != nil {
+		c.JSON(500, gin.H{"error": "error pausing queue"})
+		return
+	}
+	recordAdminAction(c, "pause_queue", audit.Entry{Queue: c.Param("queue")})
+	c.JSON(200, gin.H{"paused": true})
+}
+
+func adminResumeQueue(c *gin.Context) {
+	if err := wires.Instance.TicketService.ResumeQueue(c.Param("queue")); err != nil {
+		c.JSON(500, gin.H{"error": "error resuming queue"})
+		return
+	}
+	recordAdminAction(c, "resume_queue", audit.Entry{Queue: c.Param("queue")})
+	c.JSON(200, gin.H{"paused": false})
+}
+
+type createMatchRequest struct {
+	Team1  []string `json:"team1"`
+	Team2  []string `json:"team2"`
+	Region string   `json:"region"`
+}
+
+func adminCreateMatch(c *gin.Context) {
+	var req createMatchRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	region, ok := constants.RegionValue[req.Region]
+	if req.Region != "" && !ok {
+		c.JSON(400, gin.H{"error": "unknown region"})
+		return
+	}
+
+	matchId, err := utils.CreateMatch(c.Request.Context(), constants.GetQueueType(c.Param("queue")), region, req.Team1, req.Team2)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAdminAction(c, "create_match", audit.Entry{Queue: c.Param("queue"), MatchId: matchId, Players: append(req.Team1, req.Team2...)})
+	c.JSON(200, gin.H{"matchId": matchId})
+}
+
+func adminListMatches(c *gin.Context) {
+	c.JSON(200, gin.H{"matches": utils.ListMatches(c.Query("queue"))})
+}
+
+func adminGetMatch(c *gin.Context) {
+	matchId := c.Param("matchId")
+	match := utils.GetMatch(matchId)
+	if match == nil {
+		c.JSON(404, gin.H{"error": "match not found"})
+		return
+	}
+
+	c.JSON(200, gin.H{"match": match, "players": utils.GetMatchPlayers(matchId)})
+}
+
+// Players that didn't decline go back to the queue, with ?mode=remove everyone is removed
+func adminCancelMatch(c *gin.Context) {
+	mode := c.DefaultQuery("mode", "requeue")
+	if mode != "requeue" && mode != "remove" {
+		c.JSON(400, gin.H{"error": "mode must be requeue or remove"})
+		return
+	}
+
+	if err := utils.CancelMatch(c.Param("matchId"), mode == "requeue"); err != nil {
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAdminAction(c, "cancel_match", audit.Entry{MatchId: c.Param("matchId"), Details: map[string]interface{}{"mode": mode}})
+	c.JSON(200, gin.H{"cancelled": true})
+}
+
+func adminKickUser(c *gin.Context) {
+	userId := c.Param("userId")
+	if !ws.IsConnected(userId) {
+		c.JSON(404, gin.H{"error": "user not connected"})
+		return
+	}
+
+	ws.DisconnectUser(userId)
+	recordAdminAction(c, "kick_user", audit.Entry{UserId: userId})
+	c.JSON(200, gin.H{"disconnected": true})
+}
+
+// Entries newest first, of a match or user when given, page with ?before=<id of the last entry>
+func adminAudit(c *gin.Context) {
+	count, err := strconv.ParseInt(c.DefaultQuery("count", "50"), 10, 64)
+	if err != nil || count < 1 || count > 500 {
+		c.JSON(400, gin.H{"error": "count must be between 1 and 500"})
+		return
+	}
+	before := c.Query("before")
+
+	var entries []audit.Entry
+	switch {
+	case c.Query("matchId") != "":
+		entries, err = audit.MatchEntries(c.Query("matchId"), before, count)
+	case c.Query("userId") != "":
+		entries, err = audit.UserEntries(c.Query("userId"), before, count)
+	default:
+		entries, err = audit.Entries(before, count)
+	}
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Error reading audit", "error", err)
+		c.JSON(500, gin.H{"error": "error reading audit"})
+		return
+	}
+
+	c.JSON(200, gin.H{"entries": entries})
+}
+
+// Operators share the api key, the request is identified by its id and address
+func recordAdminAction(c *gin.Context, action string, entry audit.Entry) {
+	entry.Type = audit.AdminAction
+	if entry.Details == nil {
+		entry.Details = map[string]interface{}{}
+	}
+	entry.Details["action"] = action
+	entry.Details["requestId"] = c.Writer.Header().Get("X-Request-Id")
+	entry.Details["clientIp"] = c.ClientIP()
+	audit.Record(entry)
+}
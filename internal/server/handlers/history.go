@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"mmf/internal/history"
+	"mmf/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scheduled and cancelled matches, and those still in progress
+func RegisterHistory(router *gin.Engine, ctx context.Context) {
+	router.GET("/users/:id/matches", userMatches)
+	router.GET("/matches/:matchId", getMatch)
+}
+
+// Newest first, paged with ?offset=&limit=
+func userMatches(c *gin.Context) {
+	offset, err := strconv.ParseInt(c.DefaultQuery("offset", "0"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(400, gin.H{"error": "invalid offset"})
+		return
+	}
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "20"), 10, 64)
+	if err != nil || limit < 1 || limit > 100 {
+		c.JSON(400, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+
+	matches, total, err := history.UserMatches(c.Param("id"), offset, limit)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Error reading match history", "userId", c.Param("id"), "error", err)
+		c.JSON(500, gin.H{"error": "error reading match history"})
+		return
+	}
+
+	c.JSON(200, gin.H{"matches": matches, "total": total, "offset": offset, "limit": limit})
+}
+
+// The match with the result reported by the game server, once there is one
+func getMatch(c *gin.Context) {
+	matchId := c.Param("matchId")
+	match, err := history.GetMatch(matchId)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Error reading match history", "matchId", matchId, "error", err)
+		c.JSON(500, gin.H{"error": "error reading match history"})
+		return
+	}
+	if match == nil {
+		c.JSON(404, gin.H{"error": "match not found"})
+		return
+	}
+
+	response := gin.H{"match": match}
+	if result, err := history.GetResult(matchId); err == nil && json.Valid(result) {
+		response["result"] = json.RawMessage(result)
+	}
+	c.JSON(200, response)
+}
@@ -0,0 +1,12 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func RegisterMetrics(router *gin.Engine, ctx context.Context) {
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+}
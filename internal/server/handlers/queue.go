@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"mmf/config"
+	"mmf/internal/clock"
+	"mmf/internal/constants"
+	ws "mmf/internal/server/websockets"
+	"mmf/internal/stats"
+
+	"github.com/gin-gonic/gin"
+)
+
+// REST fallback of the websocket flow, match events are delivered on /events/:userId.
+// Players authenticate with a token signed with PLAYER_TOKEN_SECRET.
+func RegisterQueue(router *gin.Engine, ctx context.Context) {
+	secret := config.GlobalConfig.Server.PlayerTokenSecret
+	router.GET("/events/:userId", playerAuth(secret, "userId"), events)
+	router.GET("/queues/:queue/stats", queueStats)
+
+	players := router.Group("/queues/:queue/players/:id", playerAuth(secret, "id"))
+	{
+		players.POST("/join", joinQueue)
+		players.POST("/leave", leaveQueue)
+		players.POST("/matches/:matchId/accept", acceptMatch)
+		players.POST("/matches/:matchId/decline", declineMatch)
+		players.POST("/matches/:matchId/payment", submitPayment)
+	}
+}
+
+// playerAuth checks the token of the player named by the param, sent as
+// "Authorization: Bearer <token>" or in the token query for event streams.
+// Tokens are "<expiry>.<signature>", the signature is the hex HMAC-SHA256 of
+// "<userId>.<expiry>" with the secret, expiry in unix seconds.
+func playerAuth(secret string, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if secret == "" {
+			c.AbortWithStatusJSON(403, gin.H{"error": "player api is disabled"})
+			return
+		}
+
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if token == "" {
+			token = c.Query("token")
+		}
+		if !validPlayerToken(secret, c.Param(param), token) {
+			c.AbortWithStatusJSON(401, gin.H{"error": "invalid player token"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func validPlayerToken(secret string, userId string, token string) bool {
+	expiry, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || clock.Now().Unix() > expiresAt {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(userId + "." + expiry))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(signature), []byte(expected))
+}
+
+// HTTP status of each protocol error code
+var errorStatus = map[ws.ErrorCode]int{
+	ws.InvalidMessage:     400,
+	ws.UnsupportedVersion: 400,
+	ws.UnknownType:        400,
+	ws.InvalidPayload:     400,
+	ws.AlreadyInQueue:     409,
+	ws.NotInQueue:         404,
+	ws.MatchNotFound:      404,
+	ws.PaymentFailed:      402,
+	ws.NotAllowed:         409,
+	ws.InternalError:      500,
+}
+
+func events(c *gin.Context) {
+	userId := c.Param("userId")
+	queue := c.Query("queue")
+
+	if queue != "" && constants.GetQueueType(queue) == "" {
+		c.JSON(400, gin.H{"error": "unknown queue"})
+		return
+	}
+
+	ws.ServeEventStream(queue, userId, c)
+}
+
+func queueStats(c *gin.Context) {
+	queue := c.Param("queue")
+	if constants.GetQueueType(queue) == "" {
+		c.JSON(400, gin.H{"error": "unknown queue"})
+		return
+	}
+
+	snapshot, err := stats.GetQueueStats(config.GlobalConfig.MMRConfig, queue)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "error fetching queue stats"})
+		return
+	}
+
+	c.JSON(200, snapshot)
+}
+
+func joinQueue(c *gin.Context) {
+	var payload ws.JoinQueuePayload
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(400, gin.H{"error": "invalid request body"})
+			return
+		}
+	}
+
+	handleQueueRequest(c, ws.JoinQueue, payload)
+}
+
+func leaveQueue(c *gin.Context) {
+	handleQueueRequest(c, ws.LeaveQueue, nil)
+}
+
+func acceptMatch(c *gin.Context) {
+	handleQueueRequest(c, ws.SendOption, ws.UserResponse{MatchId: c.Param("matchId"), Option: 2})
+}
+
+func declineMatch(c *gin.Context) {
+	handleQueueRequest(c, ws.SendOption, ws.UserResponse{MatchId: c.Param("matchId"), Option: 0})
+}
+
+func submitPayment(c *gin.Context) {
+	var payload ws.UserPayment
+	if err := c.BindJSON(&payload); err != nil {
+		c.JSON(400, gin.H{"error": "invalid request body"})
+		return
+	}
+	payload.MatchId = c.Param("matchId")
+
+	handleQueueRequest(c, ws.SendPayment, payload)
+}
+
+// Runs the request like a websocket message, the response is the websocket reply
+func handleQueueRequest(c *gin.Context, messageType ws.MessageType, payload interface{}) {
+	queue := c.Param("queue")
+	id := c.Param("id")
+
+	if constants.GetQueueType(queue) == "" {
+		c.JSON(400, gin.H{"error": "unknown queue"})
+		return
+	}
+
+	response := ws.HandleRequest(queue, id, c.Query("walletAddress"), messageType, payload)
+	if response.Error != nil {
+		status, ok := errorStatus[response.Error.Code]
+		if !ok {
+			status = 500
+		}
+		c.JSON(status, response)
+		return
+	}
+
+	c.JSON(200, response)
+}
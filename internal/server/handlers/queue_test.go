@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+
+	"mmf/internal/clock"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const secret = "secret"
+
+func playerToken(userId string, expiry int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(userId + "." + strconv.FormatInt(expiry, 10)))
+	return strconv.FormatInt(expiry, 10) + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidPlayerToken(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1700000000, 0))
+	clock.Set(fake)
+	defer clock.Set(clock.Real{})
+
+	token := playerToken("1", 1700000060)
+	assert.True(t, validPlayerToken(secret, "1", token))
+	assert.False(t, validPlayerToken(secret, "2", token), "token of another player")
+	assert.False(t, validPlayerToken("other", "1", token), "token signed with another secret")
+	assert.False(t, validPlayerToken(secret, "1", "1700000060"), "token without signature")
+	assert.False(t, validPlayerToken(secret, "1", ""))
+
+	fake.Advance(61 * time.Second)
+	assert.False(t, validPlayerToken(secret, "1", token), "expired token")
+}
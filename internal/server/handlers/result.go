@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"mmf/config"
+	"mmf/internal/history"
+	"mmf/internal/logging"
+	"mmf/internal/matchid"
+	"mmf/pkg/client"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Results are small JSON documents, anything bigger is refused
+const maxResultSize = 1 << 20
+
+func RegisterResult(router *gin.Engine, ctx context.Context) {
+	router.POST("/results/:matchId", matchResult)
+}
+
+// Match end webhook of game servers, result is stored and forwarded to showdown.
+// Game servers authenticate with the token sent along with the webhook url.
+func matchResult(c *gin.Context) {
+	token := config.GlobalConfig.MMFApi.ApiKey
+	if token == "" {
+		c.JSON(403, gin.H{"error": "match results are disabled"})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")), []byte(token)) != 1 {
+		c.JSON(401, gin.H{"error": "invalid token"})
+		return
+	}
+
+	matchId := c.Param("matchId")
+	if !matchid.Valid(matchId) {
+		c.JSON(400, gin.H{"error": "invalid match id"})
+		return
+	}
+
+	match, err := history.GetMatch(matchId)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Error fetching match", "matchId", matchId, "error", err)
+		c.JSON(500, gin.H{"error": "error fetching match"})
+		return
+	}
+	if match == nil {
+		c.JSON(404, gin.H{"error": "match not found"})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxResultSize))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	retention := time.Duration(config.GlobalConfig.History.ResultRetentionDays) * 24 * time.Hour
+	if err := history.SaveResult(matchId, body, retention); err != nil {
+		logging.FromContext(c.Request.Context()).Error("Error storing match result", "matchId", matchId, "error", err)
+	}
+
+	if err := client.ForwardMatchResult(matchId, body); err != nil {
+		logging.FromContext(c.Request.Context()).Error("Error forwarding match result", "matchId", matchId, "error", err)
+		c.JSON(502, gin.H{"error": "error forwarding match result"})
+		return
+	}
+
+	c.Status(200)
+}
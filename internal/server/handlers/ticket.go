@@ -7,7 +7,6 @@ import (
 	"mmf/internal/calculation"
 	"mmf/internal/constants"
 	"mmf/internal/model"
-	r "mmf/internal/redis"
 	ws "mmf/internal/server/websockets"
 	"mmf/internal/wires"
 	"mmf/pkg/client"
@@ -24,7 +23,12 @@ func RegisterTicket(router *gin.Engine, ctx context.Context) {
 	}
 
 	router.GET("/ws/:queue/:id/:walletAddress", wsGet)
-	router.GET("/ws/:queue/:id", wsGetLichess)
+	router.GET("/ws/:queue/:id", wsGet)
+	router.GET("/protocol/schema.json", protocolSchema)
+}
+
+func protocolSchema(c *gin.Context) {
+	c.Data(200, "application/schema+json", ws.ProtocolSchema)
 }
 
 func testTicket(c *gin.Context) {
@@ -70,51 +74,38 @@ func testTicket(c *gin.Context) {
 				Time:       5,
 				Increment:  0,
 				Collateral: model.SP,
+				Variant:    model.Standard,
+				Color:      model.Random,
 			}}
 		}
 
-		if _, err := wires.Instance.TicketService.SubmitTicket(ticket, queue); err != nil {
+		if _, err := wires.Instance.TicketService.SubmitTicket(c.Request.Context(), ticket, queue); err != nil {
 			c.JSON(400, gin.H{"error": "error submitting ticket"})
 			return
 		}
 	}
 
 	pairs := make([]client.TestPairResponse, 0)
-	calculation.EvaluateTickets(testReq.MMRConfig, constants.GetQueueType(queue), &pairs)
-	r.RedisClient.Del(constants.GetIndexNameStr(queue))
+	calculation.EvaluateTickets(c.Request.Context(), testReq.MMRConfig, constants.GetQueueType(queue), &pairs)
+	wires.Instance.TicketService.ClearQueue(queue)
 	c.JSON(200, gin.H{"matches": pairs})
 }
 
-func wsGetLichess(c *gin.Context) {
-	queue := c.Param("queue")
-	id := c.Param("id")
-
-	if queue == "" || id == "" {
-		c.JSON(400, gin.H{"error": "missing required parameters"})
-		return
-	}
-
-	if queue != "lcqueue" {
-		c.JSON(400, gin.H{"error": "other than lcqueue is on different endpoint"})
-		return
-	}
-	ws.StartLichessWebSocket(queue, id, c)
-}
-
 func wsGet(c *gin.Context) {
 	queue := c.Param("queue")
 	id := c.Param("id")
 	walletAddress := c.Param("walletAddress")
 
-	if queue == "" || id == "" || walletAddress == "" {
+	if queue == "" || id == "" {
 		c.JSON(400, gin.H{"error": "missing required parameters"})
 		return
 	}
 
-	if queue == "lcqueue" {
-		c.JSON(400, gin.H{"error": "lcqueue is on different endpoint"})
+	if constants.GetQueueType(queue) == "" {
+		c.JSON(400, gin.H{"error": "unknown queue"})
 		return
 	}
+
 	ws.StartWebSocket(queue, id, walletAddress, c)
 }
 
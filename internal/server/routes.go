@@ -10,4 +10,9 @@ import (
 func RegisterVersion(router *gin.Engine, ctx context.Context) {
 	handlers.RegisterTicket(router, ctx)
 	handlers.RegisterHealth(router, ctx)
+	handlers.RegisterResult(router, ctx)
+	handlers.RegisterQueue(router, ctx)
+	handlers.RegisterMetrics(router, ctx)
+	handlers.RegisterAdmin(router, ctx)
+	handlers.RegisterHistory(router, ctx)
 }
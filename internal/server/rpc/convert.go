@@ -0,0 +1,126 @@
+package rpc
+
+import (
+	"mmf/internal/events"
+	"mmf/internal/model"
+	"mmf/internal/stats"
+	"mmf/pkg/mmfpb"
+)
+
+func toProtoTicket(ticket model.Ticket) *mmfpb.Ticket {
+	protoTicket := &mmfpb.Ticket{
+		UserId:        ticket.Member.Id,
+		WalletAddress: ticket.Member.WalletAddress,
+		Elo:           ticket.Score,
+		Regions:       ticket.Member.Regions,
+		JoinedAt:      ticket.Member.JoinedAt,
+		TicketId:      ticket.Member.TicketId,
+	}
+
+	if len(ticket.Member.Pings) != 0 {
+		protoTicket.Pings = make(map[string]int32, len(ticket.Member.Pings))
+		for region, ping := range ticket.Member.Pings {
+			protoTicket.Pings[region] = int32(ping)
+		}
+	}
+	for _, role := range ticket.Member.Roles {
+		protoTicket.Roles = append(protoTicket.Roles, int32(role))
+	}
+	for _, preference := range ticket.Member.LichessCustomData {
+		protoTicket.LichessPreferences = append(protoTicket.LichessPreferences, &mmfpb.LichessPreference{
+			Time:       int32(preference.Time),
+			Increment:  int32(preference.Increment),
+			Collateral: string(preference.Collateral),
+			Variant:    string(preference.Variant),
+			Rated:      preference.Rated,
+			Color:      string(preference.Color),
+		})
+	}
+
+	return protoTicket
+}
+
+func fromProtoPreferences(preferences []*mmfpb.LichessPreference) []model.LichessCustomData {
+	customData := make([]model.LichessCustomData, 0, len(preferences))
+	for _, preference := range preferences {
+		customData = append(customData, model.LichessCustomData{
+			Time:       int(preference.Time),
+			Increment:  int(preference.Increment),
+			Collateral: model.Collateral(preference.Collateral),
+			Variant:    model.Variant(preference.Variant),
+			Rated:      preference.Rated,
+			Color:      model.Color(preference.Color),
+		})
+	}
+	return customData
+}
+
+func fromProtoPings(pings map[string]int32) map[string]int {
+	if len(pings) == 0 {
+		return nil
+	}
+
+	converted := make(map[string]int, len(pings))
+	for region, ping := range pings {
+		converted[region] = int(ping)
+	}
+	return converted
+}
+
+func fromProtoRoles(roles []int32) []int {
+	if len(roles) == 0 {
+		return nil
+	}
+
+	converted := make([]int, 0, len(roles))
+	for _, role := range roles {
+		converted = append(converted, int(role))
+	}
+	return converted
+}
+
+func toProtoMatchEvent(event *events.MatchEvent) *mmfpb.MatchEvent {
+	return &mmfpb.MatchEvent{
+		Type:      mmfpb.MatchEventType(mmfpb.MatchEventType_value[string(event.Type)]),
+		MatchId:   event.MatchId,
+		Queue:     event.Queue,
+		Region:    event.Region,
+		Players:   event.Players,
+		Reason:    event.Reason,
+		Timestamp: event.Timestamp,
+	}
+}
+
+func toProtoQueueStats(queueStats *stats.QueueStats) *mmfpb.QueueStats {
+	protoStats := &mmfpb.QueueStats{
+		Queue:             queueStats.Queue,
+		PlayersWaiting:    int32(queueStats.PlayersWaiting),
+		Rating:            toProtoRatingDistribution(queueStats.Rating),
+		MatchesPerMinute:  queueStats.MatchesPerMinute,
+		MedianWaitSeconds: queueStats.MedianWaitSeconds,
+		UpdatedAt:         queueStats.UpdatedAt,
+	}
+
+	for _, pool := range queueStats.Pools {
+		protoStats.Pools = append(protoStats.Pools, &mmfpb.PoolStats{
+			Key:               pool.Key,
+			PlayersWaiting:    int32(pool.PlayersWaiting),
+			Rating:            toProtoRatingDistribution(pool.Rating),
+			MatchesPerMinute:  pool.MatchesPerMinute,
+			MedianWaitSeconds: pool.MedianWaitSeconds,
+		})
+	}
+
+	return protoStats
+}
+
+func toProtoRatingDistribution(rating stats.RatingDistribution) *mmfpb.RatingDistribution {
+	return &mmfpb.RatingDistribution{
+		Min:    rating.Min,
+		P25:    rating.P25,
+		Median: rating.Median,
+		P75:    rating.P75,
+		Max:    rating.Max,
+		Mean:   rating.Mean,
+	}
+}
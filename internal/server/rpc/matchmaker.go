@@ -0,0 +1,175 @@
+package rpc
+
+import (
+	"context"
+
+	"mmf/config"
+	"mmf/internal/constants"
+	"mmf/internal/events"
+	"mmf/internal/model"
+	ws "mmf/internal/server/websockets"
+	"mmf/internal/stats"
+	"mmf/internal/wires"
+	"mmf/pkg/mmfpb"
+	"mmf/utils"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type matchmakerServer struct {
+	mmfpb.UnimplementedMatchmakerServer
+}
+
+func (s *matchmakerServer) SubmitTicket(ctx context.Context, req *mmfpb.SubmitTicketRequest) (*mmfpb.SubmitTicketResponse, error) {
+	if err := validateQueue(req.Queue); err != nil {
+		return nil, err
+	}
+	if req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing user id")
+	}
+
+	userState := ws.GetUserState(req.UserId)
+	if userState.State != model.NoState || wires.Instance.TicketService.GetTicket(req.Queue, req.UserId) != nil {
+		return nil, status.Error(codes.AlreadyExists, "user already part of queue")
+	}
+
+	payload := &ws.JoinQueuePayload{
+		Preferences: fromProtoPreferences(req.LichessPreferences),
+		Regions:     req.Regions,
+		Pings:       fromProtoPings(req.Pings),
+		Roles:       fromProtoRoles(req.Roles),
+	}
+	ticket, err := ws.BuildServiceTicket(req.Queue, req.UserId, req.WalletAddress, req.Elo, payload)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	memberData, err := wires.Instance.TicketService.SubmitTicket(ctx, *ticket, req.Queue)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "error submitting ticket")
+	}
+
+	return &mmfpb.SubmitTicketResponse{Ticket: toProtoTicket(model.Ticket{Member: *memberData, Score: ticket.Elo})}, nil
+}
+
+func (s *matchmakerServer) CancelTicket(ctx context.Context, req *mmfpb.CancelTicketRequest) (*mmfpb.CancelTicketResponse, error) {
+	if err := validateQueue(req.Queue); err != nil {
+		return nil, err
+	}
+
+	if wires.Instance.TicketService.GetTicket(req.Queue, req.UserId) == nil {
+		return nil, status.Error(codes.NotFound, "ticket not found")
+	}
+
+	if err := wires.Instance.TicketService.DeleteTicket(req.Queue, req.UserId); err != nil {
+		return nil, status.Error(codes.Internal, "error cancelling ticket")
+	}
+	return &mmfpb.CancelTicketResponse{}, nil
+}
+
+func (s *matchmakerServer) RefreshTicket(ctx context.Context, req *mmfpb.RefreshTicketRequest) (*mmfpb.RefreshTicketResponse, error) {
+	if err := validateQueue(req.Queue); err != nil {
+		return nil, err
+	}
+
+	if wires.Instance.TicketService.GetTicket(req.Queue, req.UserId) == nil {
+		return nil, status.Error(codes.NotFound, "ticket not found")
+	}
+
+	if err := wires.Instance.TicketService.RefreshTickets(req.Queue, []string{req.UserId}); err != nil {
+		return nil, status.Error(codes.Internal, "error refreshing ticket")
+	}
+	return &mmfpb.RefreshTicketResponse{}, nil
+}
+
+func (s *matchmakerServer) WatchMatches(req *mmfpb.WatchMatchesRequest, stream mmfpb.Matchmaker_WatchMatchesServer) error {
+	matchEvents, unsubscribe := events.SubscribeMatchEvents()
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event := <-matchEvents:
+			if !matchesFilter(req, &event) {
+				continue
+			}
+			if err := stream.Send(toProtoMatchEvent(&event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *matchmakerServer) GetQueueStats(ctx context.Context, req *mmfpb.GetQueueStatsRequest) (*mmfpb.QueueStats, error) {
+	if err := validateQueue(req.Queue); err != nil {
+		return nil, err
+	}
+
+	queueStats, err := stats.GetQueueStats(config.GlobalConfig.MMRConfig, req.Queue)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "error fetching queue stats")
+	}
+	return toProtoQueueStats(queueStats), nil
+}
+
+func (s *matchmakerServer) ListTickets(ctx context.Context, req *mmfpb.ListTicketsRequest) (*mmfpb.ListTicketsResponse, error) {
+	if err := validateQueue(req.Queue); err != nil {
+		return nil, err
+	}
+
+	tickets := wires.Instance.TicketService.GetAllTickets(req.Queue)
+	if tickets == nil {
+		return nil, status.Error(codes.Internal, "error fetching tickets")
+	}
+
+	response := &mmfpb.ListTicketsResponse{}
+	for _, ticket := range *tickets {
+		response.Tickets = append(response.Tickets, toProtoTicket(ticket))
+	}
+	return response, nil
+}
+
+func (s *matchmakerServer) ClearQueue(ctx context.Context, req *mmfpb.ClearQueueRequest) (*mmfpb.ClearQueueResponse, error) {
+	if err := validateQueue(req.Queue); err != nil {
+		return nil, err
+	}
+
+	if _, err := utils.FlushQueue(req.Queue); err != nil {
+		return nil, status.Error(codes.Internal, "error clearing queue")
+	}
+	return &mmfpb.ClearQueueResponse{}, nil
+}
+
+func (s *matchmakerServer) CancelMatch(ctx context.Context, req *mmfpb.CancelMatchRequest) (*mmfpb.CancelMatchResponse, error) {
+	if err := utils.CancelMatch(req.MatchId, !req.RemovePlayers); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &mmfpb.CancelMatchResponse{}, nil
+}
+
+func validateQueue(queue string) error {
+	if constants.GetQueueType(queue) == "" {
+		return status.Error(codes.InvalidArgument, "unknown queue")
+	}
+	return nil
+}
+
+func matchesFilter(req *mmfpb.WatchMatchesRequest, event *events.MatchEvent) bool {
+	if req.Queue != "" && req.Queue != event.Queue {
+		return false
+	}
+	if req.MatchId != "" && req.MatchId != event.MatchId {
+		return false
+	}
+	if req.UserId != "" {
+		for _, playerId := range event.Players {
+			if playerId == req.UserId {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net"
+	"strings"
+
+	"mmf/config"
+	"mmf/pkg/mmfpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Start serves the gRPC api for backend services, it blocks until the listener fails
+func Start(serverConfig config.ServerConfig) {
+	if serverConfig.GrpcApiKey == "" {
+		slog.Warn("GRPC_API_KEY is not set, the grpc api is disabled")
+		return
+	}
+
+	listener, err := net.Listen("tcp", ":"+serverConfig.GrpcPort)
+	if err != nil {
+		slog.Error("Could not start the grpc server", "error", err)
+		return
+	}
+
+	auth := &apiKeyAuth{apiKey: serverConfig.GrpcApiKey}
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(auth.unary),
+		grpc.StreamInterceptor(auth.stream),
+	)
+	mmfpb.RegisterMatchmakerServer(grpcServer, &matchmakerServer{})
+
+	slog.Info("Starting grpc server", "port", serverConfig.GrpcPort)
+	if err := grpcServer.Serve(listener); err != nil {
+		slog.Error("Grpc server stopped", "error", err)
+	}
+}
+
+// Services authenticate with "authorization: Bearer <GRPC_API_KEY>" metadata
+type apiKeyAuth struct {
+	apiKey string
+}
+
+func (a *apiKeyAuth) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *apiKeyAuth) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := a.authorize(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+func (a *apiKeyAuth) authorize(ctx context.Context) error {
+	if a.apiKey == "" {
+		return status.Error(codes.Unauthenticated, "grpc api is disabled")
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		token := strings.TrimPrefix(value, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.apiKey)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid api key")
+}
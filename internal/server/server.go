@@ -2,17 +2,25 @@ package server
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"os"
 	"time"
 
 	"mmf/config"
+	"mmf/internal/clock"
+	"mmf/internal/logging"
 	"mmf/internal/redis"
 	"mmf/internal/redis/crawler"
+	"mmf/internal/server/rpc"
+	ws "mmf/internal/server/websockets"
 	"mmf/internal/wires"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
+const requestIdHeader = "X-Request-Id"
+
 type Server struct {
 	config *config.Config
 }
@@ -26,25 +34,53 @@ func NewServer(config *config.Config) *Server {
 func (server *Server) Start() {
 	InitCrawler(server.config.MMRConfig)
 
-	r := gin.Default()
-	r.Use(gin.Logger())
+	r := gin.New()
+	r.Use(RequestLogger())
 	r.Use(CORSMiddleware())
 
 	wires.Init(server.config)
 	redis.Init(server.config, context.Background())
+	ws.StartTicketHeartbeat(server.config.MMRConfig.TicketTTL)
 	// Recovery middleware recovers from any panics and writes a 500 if there was one.
 	r.Use(gin.Recovery())
 
 	RegisterVersion(r, context.Background())
 
+	go rpc.Start(server.config.Server)
+
+	slog.Info("Starting server", "port", server.config.Server.Port)
 	err := r.Run(":" + server.config.Server.Port)
 
 	if err != nil {
-		log.Fatal("Could not start the server" + err.Error())
-		return
+		slog.Error("Could not start the server", "error", err)
+		os.Exit(1)
 	}
+}
+
+// RequestLogger tags each request with an id, taken from the X-Request-Id
+// header when the caller sets one, and logs it once it completes
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		requestId := c.GetHeader(requestIdHeader)
+		if requestId == "" {
+			requestId = uuid.NewString()
+		}
+		c.Header(requestIdHeader, requestId)
+
+		logger := slog.With("requestId", requestId)
+		c.Request = c.Request.WithContext(logging.WithLogger(c.Request.Context(), logger))
 
-	println("Starting server on port: " + server.config.Server.Port)
+		c.Next()
+
+		logger.Info("Request",
+			"method", c.Request.Method,
+			"path", c.FullPath(),
+			"status", c.Writer.Status(),
+			"duration", time.Since(start),
+			"clientIp", c.ClientIP(),
+		)
+	}
 }
 
 func CORSMiddleware() gin.HandlerFunc {
@@ -60,12 +96,12 @@ func CORSMiddleware() gin.HandlerFunc {
 
 func InitCrawler(config config.MMRConfig) bool {
 
-	ticker := time.NewTicker(time.Duration(config.Interval) * time.Second)
+	ticker := clock.NewTicker(time.Duration(config.Interval) * time.Second)
 	quit := make(chan struct{})
 	go func() bool {
 		for {
 			select {
-			case <-ticker.C:
+			case <-ticker.C():
 				flag := crawler.StartCrawler(config)
 
 				if !flag {
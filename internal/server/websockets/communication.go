@@ -1,11 +1,8 @@
 package ws
 
 import (
-	"log"
+	"log/slog"
 	"mmf/internal/model"
-	"time"
-
-	"github.com/gorilla/websocket"
 )
 
 func SendMatchFoundToPlayers(matchId string, matchTickets []model.Ticket, timeToAccept int64) bool {
@@ -18,71 +15,37 @@ func SendMatchFoundToPlayers(matchId string, matchTickets []model.Ticket, timeTo
 }
 
 func SendMessageToUser(id string, event EventType, message string) {
-	userConnectionsMutex.Lock()
-	defer userConnectionsMutex.Unlock()
-
-	conn, ok := userConnections[id]
-	if !ok {
-		log.Println("User not connected")
-		return
-	}
-
-	if err := conn.WriteJSON(GetMessage(event, message)); err != nil {
-		log.Println(err)
-	}
+	SendJSONToUser(id, event, message)
 }
 
+// SendJSONToUser queues the event on every session of the user, it never
+// blocks on the network so a slow client can't hold up other users
 func SendJSONToUser(id string, event EventType, message interface{}) {
-	userConnectionsMutex.Lock()
-	defer userConnectionsMutex.Unlock()
-
-	conn, ok := userConnections[id]
-	if !ok {
-		log.Println("User not connected")
+	connections := getUserConnections(id)
+	if len(connections) == 0 {
+		slog.Debug("User not connected", "userId", id, "eventType", event)
 		return
 	}
 
-	SendJSON(conn, event, message)
+	for _, conn := range connections {
+		SendJSON(conn, event, message)
+	}
 }
 
 func DisconnectUser(steamId string) {
-	userConnectionsMutex.Lock()
-	defer userConnectionsMutex.Unlock()
-
-	conn, ok := userConnections[steamId]
-	if !ok {
-		log.Println("User not connected")
+	connections := getUserConnections(steamId)
+	if len(connections) == 0 {
+		slog.Debug("User not connected", "userId", steamId)
 		return
 	}
 
-	// Close the connection
-	if err := conn.Close(); err != nil {
-		log.Println("Error closing connection:", err)
+	// Close handshake is done by the writer, the read loops then remove the connections
+	for _, conn := range connections {
+		conn.Close()
 	}
-
-	// Remove the connection from the map
-	delete(userConnections, steamId)
 }
 
-// Function to periodically send pings
-func sendPings(conn *websocket.Conn) {
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-			log.Println("Error sending ping:", err)
-			conn.Close()
-			return
-		}
-	}
-}
-
-func SendJSON(conn *websocket.Conn, eventType EventType, message interface{}) {
-	if err := conn.WriteJSON(map[string]interface{}{
-		"eventType": eventType,
-		"message":   message,
-	}); err != nil {
-		log.Println(err)
-	}
+// SendJSON sends an event that is not a response to any client request
+func SendJSON(conn *Connection, eventType EventType, message interface{}) {
+	Reply(conn, "", eventType, message)
 }
@@ -0,0 +1,187 @@
+package ws
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Time allowed to write a message to the peer
+	writeWait = 10 * time.Second
+	// Time allowed to read the next pong or message from the peer
+	pongWait = 20 * time.Second
+	// Send pings to peer with this period, must be less than pongWait
+	pingPeriod = 10 * time.Second
+	// Messages queued for a client before it's considered too slow and evicted
+	sendBufferSize = 64
+)
+
+type outboundMessage struct {
+	messageType int
+	data        []byte
+}
+
+// Connection owns a websocket, gorilla allows a single concurrent writer so
+// every write goes through the send channel drained by the writer goroutine.
+// Event stream sessions use the same type without a socket.
+type Connection struct {
+	UserId string
+	Queue  string
+
+	conn      *websocket.Conn
+	send      chan outboundMessage
+	done      chan struct{}
+	closeOnce sync.Once
+	closeCode int
+	closeText string
+}
+
+func NewConnection(conn *websocket.Conn, userId string, queue string) *Connection {
+	c := &Connection{
+		UserId:    userId,
+		Queue:     queue,
+		conn:      conn,
+		send:      make(chan outboundMessage, sendBufferSize),
+		done:      make(chan struct{}),
+		closeCode: websocket.CloseNormalClosure,
+	}
+
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(appData string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait)) // Reset read deadline when pong is received
+		c.sendMessage(websocket.TextMessage, []byte("pong"))
+		return nil
+	})
+
+	go c.writePump()
+	return c
+}
+
+// newStreamConnection creates a connection without a socket, the owner drains
+// the send channel itself, e.g. an event stream or a single REST request
+func newStreamConnection(userId string, queue string) *Connection {
+	return &Connection{
+		UserId:    userId,
+		Queue:     queue,
+		send:      make(chan outboundMessage, sendBufferSize),
+		done:      make(chan struct{}),
+		closeCode: websocket.CloseNormalClosure,
+	}
+}
+
+// SendJSON queues the message, clients not keeping up are disconnected
+// instead of blocking the sender
+func (c *Connection) SendJSON(message interface{}) bool {
+	data, err := json.Marshal(message)
+	if err != nil {
+		slog.Error("Error marshalling message", "userId", c.UserId, "error", err)
+		return false
+	}
+
+	return c.sendMessage(websocket.TextMessage, data)
+}
+
+func (c *Connection) sendMessage(messageType int, data []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- outboundMessage{messageType: messageType, data: data}:
+		return true
+	default:
+		slog.Warn("Send buffer full, evicting slow client", "userId", c.UserId, "queue", c.Queue)
+		c.CloseWithReason(websocket.ClosePolicyViolation, "Too slow")
+		return false
+	}
+}
+
+// ReadMessage reads the next message, any message from the peer proves it's alive
+func (c *Connection) ReadMessage() ([]byte, error) {
+	_, message, err := c.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	return message, nil
+}
+
+func (c *Connection) Close() {
+	c.CloseWithReason(websocket.CloseNormalClosure, "")
+}
+
+// CloseWithReason stops the writer, which sends the close frame after
+// flushing queued messages and closes the socket
+func (c *Connection) CloseWithReason(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeText = text
+		close(c.done)
+	})
+}
+
+// Done is closed once the connection is closing
+func (c *Connection) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Connection) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message := <-c.send:
+			if err := c.write(message.messageType, message.data); err != nil {
+				slog.Debug("Error writing message", "userId", c.UserId, "error", err)
+				c.CloseWithReason(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ticker.C:
+			if err := c.write(websocket.PingMessage, nil); err != nil {
+				slog.Debug("Error sending ping", "userId", c.UserId, "error", err)
+				c.CloseWithReason(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-c.done:
+			if c.closeCode == websocket.CloseAbnormalClosure {
+				return
+			}
+			// Slow clients are not waited for
+			if c.closeCode == websocket.CloseNormalClosure {
+				c.flush()
+			}
+			c.write(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeText))
+			return
+		}
+	}
+}
+
+// Writes what's already queued, e.g. the reason a match was cancelled
+func (c *Connection) flush() {
+	for {
+		select {
+		case message := <-c.send:
+			if err := c.write(message.messageType, message.data); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (c *Connection) write(messageType int, data []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.conn.WriteMessage(messageType, data)
+}
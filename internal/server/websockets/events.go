@@ -0,0 +1,120 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"mmf/internal/logging"
+	"mmf/internal/model"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServeEventStream streams the events a websocket session would receive as
+// Server-Sent Events, for clients that can't hold a websocket. With a queue
+// the stream counts as a session on that queue and keeps the ticket alive.
+func ServeEventStream(queue string, id string, c *gin.Context) {
+	conn := newStreamConnection(id, queue)
+	defer conn.Close()
+
+	sessions, ok := addSession(conn)
+	if !ok {
+		c.JSON(429, gin.H{"error": "too many sessions open"})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(200)
+	c.Writer.Flush()
+
+	restoreSession(conn, sessions)
+	defer closeSession(conn, sessions)
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message := <-conn.send:
+			if _, err := fmt.Fprintf(c.Writer, "data: %s\n\n", message.data); err != nil {
+				logging.User(id, queue).Warn("Error writing event", "error", err)
+				return
+			}
+			c.Writer.Flush()
+		case <-ticker.C:
+			// Comment line keeps proxies from closing an idle stream
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-conn.Done():
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// HandleRequest runs a single client request outside of a websocket, e.g. from
+// the REST endpoints, and returns the response a websocket session would get.
+// Events caused by the request reach the user's open sessions as usual.
+func HandleRequest(queue string, id string, walletAddress string, messageType MessageType, payload interface{}) ServerMessage {
+	conn := newStreamConnection(id, queue)
+	defer conn.Close()
+
+	queueHandler := GetQueueHandler(queue)
+	if queueHandler == nil {
+		return errorMessage(NewProtocolError(InvalidMessage, "Unknown queue"))
+	}
+
+	message := &ClientMessage{Version: ProtocolVersion, Type: messageType}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return errorMessage(NewProtocolError(InvalidPayload, "Error parsing payload"))
+		}
+		message.Payload = data
+	}
+
+	// Rating and payment info are only needed to join and to pay
+	var player *Player
+	if messageType == JoinQueue || messageType == SendPayment {
+		var err error
+		player, err = queueHandler.Connect(id, walletAddress)
+		if err != nil {
+			logging.User(id, queue).Error("Error connecting player", "error", err)
+			return errorMessage(NewProtocolError(InternalError, "Error getting player info"))
+		}
+	}
+
+	sessions := getSessions(id)
+	if sessions == nil {
+		// Not registered, nothing to share the ticket data with
+		sessions = &userSessions{memberData: make(map[string]*model.MemberData)}
+	}
+
+	sessions.actionMutex.Lock()
+	defer sessions.actionMutex.Unlock()
+
+	// Match events are only delivered to open sessions and the ticket is
+	// removed with the last one, so joining needs a session on the queue
+	if messageType == JoinQueue && !hasQueueSession(id, queue) {
+		return errorMessage(NewProtocolError(NotAllowed, "Open an event stream or websocket on the queue before joining"))
+	}
+
+	handleClientMessage(conn, sessions, queueHandler, player, message)
+
+	select {
+	case response := <-conn.send:
+		var serverMessage ServerMessage
+		if err := json.Unmarshal(response.data, &serverMessage); err != nil {
+			return errorMessage(NewProtocolError(InternalError, "Error reading response"))
+		}
+		return serverMessage
+	default:
+		return errorMessage(NewProtocolError(InternalError, "No response"))
+	}
+}
@@ -0,0 +1,34 @@
+package ws
+
+import (
+	"log/slog"
+	"mmf/internal/clock"
+	"mmf/internal/wires"
+	"time"
+)
+
+// StartTicketHeartbeat keeps alive the tickets of users with a session open on
+// their queue, every replica refreshes its own sessions. Tickets nobody
+// refreshes expire after ttl seconds and are removed by the crawler.
+func StartTicketHeartbeat(ttl int) {
+	if ttl <= 0 {
+		return
+	}
+
+	// Several refreshes per ttl so a slow or failed one doesn't expire live tickets
+	ticker := clock.NewTicker(time.Duration(ttl) * time.Second / 3)
+	go func() {
+		defer ticker.Stop()
+		for range ticker.C() {
+			refreshTickets()
+		}
+	}()
+}
+
+func refreshTickets() {
+	for queue, userIds := range queueSessionUsers() {
+		if err := wires.Instance.TicketService.RefreshTickets(queue, userIds); err != nil {
+			slog.Error("Error refreshing tickets", "queue", queue, "count", len(userIds), "error", err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package ws
+
+import (
+	_ "embed"
+	"encoding/json"
+	"mmf/internal/matchid"
+	"mmf/internal/model"
+)
+
+// Version of the websocket protocol, messages without version are treated as
+// version 0 - the format used before the envelope was introduced
+const ProtocolVersion = 1
+
+// JSON Schema of client and server messages, published for client teams
+//
+//go:embed protocol.schema.json
+var ProtocolSchema []byte
+
+// Envelope of every message sent by clients
+type ClientMessage struct {
+	Version   int             `json:"v"`
+	RequestId string          `json:"requestId,omitempty"`
+	Type      MessageType     `json:"type"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+// Envelope of every message sent by the server, message holds the payload for
+// clients still reading the version 0 format
+type ServerMessage struct {
+	Version   int            `json:"v"`
+	RequestId string         `json:"requestId,omitempty"`
+	EventType EventType      `json:"eventType"`
+	Message   interface{}    `json:"message"`
+	Error     *ProtocolError `json:"error,omitempty"`
+}
+
+type ErrorCode string
+
+const (
+	InvalidMessage     ErrorCode = "INVALID_MESSAGE"
+	UnsupportedVersion ErrorCode = "UNSUPPORTED_VERSION"
+	UnknownType        ErrorCode = "UNKNOWN_TYPE"
+	InvalidPayload     ErrorCode = "INVALID_PAYLOAD"
+	AlreadyInQueue     ErrorCode = "ALREADY_IN_QUEUE"
+	NotInQueue         ErrorCode = "NOT_IN_QUEUE"
+	MatchNotFound      ErrorCode = "MATCH_NOT_FOUND"
+	PaymentFailed      ErrorCode = "PAYMENT_FAILED"
+	NotAllowed         ErrorCode = "NOT_ALLOWED"
+	InternalError      ErrorCode = "INTERNAL_ERROR"
+)
+
+type ProtocolError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+func (pe *ProtocolError) Error() string {
+	return string(pe.Code) + ": " + pe.Message
+}
+
+func NewProtocolError(code ErrorCode, message string) *ProtocolError {
+	return &ProtocolError{Code: code, Message: message}
+}
+
+type JoinQueuePayload struct {
+	Preferences []model.LichessCustomData `json:"preferences,omitempty"` // Lichess only
+	Regions     []string                  `json:"regions,omitempty"`
+	Pings       map[string]int            `json:"pings,omitempty"`
+	Roles       []int                     `json:"roles,omitempty"` // Dota 2 only
+}
+
+// ParseClientMessage decodes the envelope, version 0 messages are converted:
+// bare SEND_OPTION and SEND_PAYMENT payloads and JOIN_QUEUE with a list of preferences
+func ParseClientMessage(data []byte) (*ClientMessage, *ProtocolError) {
+	var message ClientMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, NewProtocolError(InvalidMessage, "Error parsing message")
+	}
+
+	if message.Version > ProtocolVersion {
+		return &message, NewProtocolError(UnsupportedVersion, "Unsupported protocol version")
+	}
+
+	if message.Version == 0 {
+		convertLegacyMessage(data, &message)
+	}
+
+	if _, ok := MessageTypeValues[string(message.Type)]; !ok {
+		return &message, NewProtocolError(UnknownType, "Invalid message type")
+	}
+
+	return &message, nil
+}
+
+func convertLegacyMessage(data []byte, message *ClientMessage) {
+	switch message.Type {
+	case "":
+		var legacy struct {
+			Option  *int    `json:"option"`
+			TxnHash *string `json:"txnHash"`
+		}
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return
+		}
+
+		if legacy.TxnHash != nil {
+			message.Type = SendPayment
+		} else if legacy.Option != nil {
+			message.Type = SendOption
+		}
+		message.Payload = data
+	case JoinQueue:
+		var preferences []model.LichessCustomData
+		if err := json.Unmarshal(message.Payload, &preferences); err != nil {
+			return
+		}
+
+		payload, err := json.Marshal(JoinQueuePayload{Preferences: preferences})
+		if err != nil {
+			return
+		}
+		message.Payload = payload
+	}
+}
+
+// DecodePayload unmarshals the payload of the message into target
+func (cm *ClientMessage) DecodePayload(target interface{}) *ProtocolError {
+	if len(cm.Payload) == 0 || string(cm.Payload) == "null" {
+		return NewProtocolError(InvalidPayload, "Missing payload")
+	}
+
+	if err := json.Unmarshal(cm.Payload, target); err != nil {
+		return NewProtocolError(InvalidPayload, "Error parsing payload")
+	}
+
+	return nil
+}
+
+// Match ids are used as Redis keys, anything that isn't one is refused before the lookup
+func validateMatchId(matchId string) *ProtocolError {
+	if !matchid.Valid(matchId) {
+		return NewProtocolError(InvalidPayload, "Invalid match id")
+	}
+	return nil
+}
+
+// Reply sends a response correlated with the client request
+func Reply(conn *Connection, requestId string, eventType EventType, message interface{}) {
+	writeServerMessage(conn, ServerMessage{Version: ProtocolVersion, RequestId: requestId, EventType: eventType, Message: message})
+}
+
+// ReplyError sends a typed error correlated with the client request
+func ReplyError(conn *Connection, requestId string, protocolError *ProtocolError) {
+	message := errorMessage(protocolError)
+	message.RequestId = requestId
+	writeServerMessage(conn, message)
+}
+
+// Envelope of a typed error
+func errorMessage(protocolError *ProtocolError) ServerMessage {
+	return ServerMessage{
+		Version:   ProtocolVersion,
+		EventType: Error,
+		Message:   protocolError.Message,
+		Error:     protocolError,
+	}
+}
+
+func writeServerMessage(conn *Connection, message ServerMessage) {
+	conn.SendJSON(message)
+}
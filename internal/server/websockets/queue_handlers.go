@@ -0,0 +1,197 @@
+package ws
+
+import (
+	"fmt"
+	"log/slog"
+	"mmf/internal/clock"
+	"mmf/internal/constants"
+	"mmf/internal/model"
+	"mmf/pkg/external"
+	"slices"
+)
+
+// Player resolved when the connection opens
+type Player struct {
+	Id            string
+	WalletAddress string
+	PaymentId     string // Id the player signs on-chain payments with
+	ratings       map[string]float64
+}
+
+// QueueHandler plugs game specific behaviour into the websocket flow
+type QueueHandler interface {
+	// Connect resolves wallet, payment id and ratings of the player
+	Connect(id string, walletAddress string) (*Player, error)
+	// BuildTicket validates the JOIN_QUEUE payload and builds the ticket
+	BuildTicket(player *Player, payload *JoinQueuePayload) (*model.SubmitTicketRequest, error)
+}
+
+func GetQueueHandler(queue string) QueueHandler {
+	switch constants.GetQueueType(queue) {
+	case constants.LCQueue, constants.LCQueueTest:
+		return &lichessQueueHandler{}
+	case constants.CS2Queue:
+		return &teamQueueHandler{}
+	case constants.D2Queue:
+		return &teamQueueHandler{withRoles: true}
+	default:
+		return nil
+	}
+}
+
+// BuildServiceTicket validates a ticket a backend service submits on behalf
+// of a player, the service provides the rating instead of the game apis.
+// Service tickets don't expire, the service cancels them.
+func BuildServiceTicket(queue string, id string, walletAddress string, elo float64, payload *JoinQueuePayload) (*model.SubmitTicketRequest, error) {
+	queueHandler := GetQueueHandler(queue)
+	if queueHandler == nil {
+		return nil, fmt.Errorf("unknown queue %s", queue)
+	}
+	if _, ok := queueHandler.(*teamQueueHandler); ok && walletAddress == "" {
+		return nil, fmt.Errorf("missing wallet address")
+	}
+
+	player := &Player{Id: id, WalletAddress: walletAddress, PaymentId: id, ratings: map[string]float64{}}
+	ticket, err := queueHandler.BuildTicket(player, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if elo > 0 {
+		ticket.Elo = elo
+	}
+	ticket.NoExpiry = true
+	return ticket, nil
+}
+
+type lichessQueueHandler struct{}
+
+func (h *lichessQueueHandler) Connect(id string, _ string) (*Player, error) {
+	walletAddress, err := idToWallet(id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting wallet address: %s", err)
+	}
+
+	showdownUser, err := idToApiKey(id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting token from showdown api: %s", err)
+	}
+
+	player := &Player{Id: id, WalletAddress: walletAddress.WalletAddress, PaymentId: showdownUser.LichessId, ratings: map[string]float64{}}
+
+	perfs, err := external.GetLichessPerfs(showdownUser.LichessToken)
+	if err != nil {
+		slog.Warn("Error getting perfs from lichess, using default elo 1500", "userId", id, "error", err)
+	}
+	for perf, performance := range perfs {
+		player.ratings[perf] = float64(performance.Rating)
+	}
+
+	return player, nil
+}
+
+func (h *lichessQueueHandler) BuildTicket(player *Player, payload *JoinQueuePayload) (*model.SubmitTicketRequest, error) {
+	preferences := payload.Preferences
+	if length := len(preferences); length == 0 || length > 3 {
+		return nil, fmt.Errorf("Invalid payload, must be between 1 and 3")
+	}
+
+	if err := validateLichessCustomData(preferences); err != nil {
+		return nil, err
+	}
+
+	for i := range preferences {
+		preferences[i].Timestamp = clock.Now().Unix()
+	}
+
+	// Ticket is scored with the rating of the most preferred variant and speed
+	elo := 1500.0
+	if rating, ok := player.ratings[preferences[0].PerfType()]; ok {
+		elo = rating
+	}
+
+	return &model.SubmitTicketRequest{
+		Id:                player.Id,
+		Elo:               elo,
+		WalletAddress:     player.WalletAddress,
+		LichessCustomData: preferences,
+	}, nil
+}
+
+// CS2 and Dota 2 - wallet comes with the connection, rating from the stats relay
+type teamQueueHandler struct {
+	withRoles bool
+}
+
+func (h *teamQueueHandler) Connect(id string, walletAddress string) (*Player, error) {
+	if walletAddress == "" {
+		return nil, fmt.Errorf("missing wallet address")
+	}
+
+	eloData := external.GetDataFromRelay(id)
+	return &Player{Id: id, WalletAddress: walletAddress, PaymentId: id, ratings: map[string]float64{"": eloData.Elo}}, nil
+}
+
+func (h *teamQueueHandler) BuildTicket(player *Player, payload *JoinQueuePayload) (*model.SubmitTicketRequest, error) {
+	for _, region := range payload.Regions {
+		if _, ok := constants.RegionValue[region]; !ok {
+			return nil, fmt.Errorf("Invalid region %s", region)
+		}
+	}
+
+	if len(payload.Roles) != 0 && !h.withRoles {
+		return nil, fmt.Errorf("Roles are not supported in this queue")
+	}
+	for i, role := range payload.Roles {
+		if role < 1 || role > 5 || slices.Contains(payload.Roles[:i], role) {
+			return nil, fmt.Errorf("Invalid role %d", role)
+		}
+	}
+
+	if err := validatePings(payload.Pings); err != nil {
+		return nil, err
+	}
+
+	return &model.SubmitTicketRequest{
+		Id:            player.Id,
+		Elo:           player.ratings[""],
+		WalletAddress: player.WalletAddress,
+		Regions:       payload.Regions,
+		Pings:         payload.Pings,
+		Roles:         payload.Roles,
+	}, nil
+}
+
+func validatePings(pings map[string]int) error {
+	for region, ping := range pings {
+		if _, ok := constants.RegionValue[region]; !ok {
+			return fmt.Errorf("Invalid region %s", region)
+		}
+		if ping < 0 {
+			return fmt.Errorf("Invalid ping for region %s", region)
+		}
+	}
+
+	return nil
+}
+
+// Fills in defaults for variant and color and rejects unknown values
+func validateLichessCustomData(payload []model.LichessCustomData) error {
+	for i := range payload {
+		if payload[i].Variant == "" {
+			payload[i].Variant = model.Standard
+		}
+		if _, ok := model.VariantValue[string(payload[i].Variant)]; !ok || payload[i].Variant == model.FromPosition {
+			return fmt.Errorf("Invalid variant %s", payload[i].Variant)
+		}
+
+		if payload[i].Color == "" {
+			payload[i].Color = model.Random
+		}
+		if _, ok := model.ColorValue[string(payload[i].Color)]; !ok {
+			return fmt.Errorf("Invalid color %s", payload[i].Color)
+		}
+	}
+
+	return nil
+}
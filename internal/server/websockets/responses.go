@@ -14,6 +14,8 @@ const (
 	LeaveQueue  MessageType = "LEAVE_QUEUE"
 	SendPayment MessageType = "SEND_PAYMENT"
 	SendOption  MessageType = "SEND_OPTION"
+	BanMap      MessageType = "BAN_MAP"
+	ReportPing  MessageType = "REPORT_PING"
 )
 
 var MessageTypeValues = map[string]MessageType{
@@ -21,29 +23,42 @@ var MessageTypeValues = map[string]MessageType{
 	"LEAVE_QUEUE":  LeaveQueue,
 	"SEND_PAYMENT": SendPayment,
 	"SEND_OPTION":  SendOption,
+	"BAN_MAP":      BanMap,
+	"REPORT_PING":  ReportPing,
 }
 
-type UserMessage struct {
-	Type    MessageType `json:"type"`
-	Payload interface{} `json:"payload"`
+type UserPayment struct {
+	MatchId string `json:"matchId"`
+	TxnHash string `json:"txnHash"`
 }
 
-type UserJoinQueue struct {
-	Type    MessageType             `json:"type"`
-	Payload model.LichessCustomData `json:"payload"`
+type UserMapBan struct {
+	MatchId string `json:"matchId"`
+	Map     string `json:"map"`
 }
 
-type UserPayment struct {
-	MatchId string `json:"matchId"`
-	TxnHash string `json:"txnHash"`
+type UserPings struct {
+	Pings map[string]int `json:"pings"` // Ping in ms per region
 }
 
 type MatchFoundResponse struct {
-	MatchId    string          `json:"matchId"`
-	ExpiryTime int64           `json:"expiryTime"`
-	TeamA      []model.Ticket  `json:"teamA"`
-	TeamB      []model.Ticket  `json:"teamB"`
-	State      model.UserState `json:"state"`
+	MatchId    string             `json:"matchId"`
+	ExpiryTime int64              `json:"expiryTime"`
+	TeamA      []MatchFoundPlayer `json:"teamA"`
+	TeamB      []MatchFoundPlayer `json:"teamB"`
+	State      model.UserState    `json:"state"`
+}
+
+// Player of a found match as the other players see it, in the shape of a
+// ticket without wallets, preferences or trace context
+type MatchFoundPlayer struct {
+	Member MatchFoundMember `json:"member"`
+	Score  float64          `json:"score"`
+	Role   int              `json:"role,omitempty"`
+}
+
+type MatchFoundMember struct {
+	Id string `json:"id"`
 }
 
 type BackToMatchMakingResponse struct {
@@ -54,11 +69,27 @@ type BackToMatchMakingResponse struct {
 func GenerateMatchFoundResponse(tickets []model.Ticket, matchId string, expiryTime int64) MatchFoundResponse {
 	mess := MatchFoundResponse{MatchId: matchId, ExpiryTime: expiryTime, State: model.MatchFound}
 	mid := len(tickets) / 2
-	mess.TeamA = tickets[:mid]
-	mess.TeamB = tickets[mid:]
+	mess.TeamA = matchFoundPlayers(tickets[:mid])
+	mess.TeamB = matchFoundPlayers(tickets[mid:])
 	return mess
 }
 
+func matchFoundPlayers(tickets []model.Ticket) []MatchFoundPlayer {
+	players := make([]MatchFoundPlayer, 0, len(tickets))
+	for _, ticket := range tickets {
+		players = append(players, MatchFoundPlayer{Member: MatchFoundMember{Id: ticket.Member.Id}, Score: ticket.Score, Role: ticket.Role})
+	}
+	return players
+}
+
+// Sent periodically to players waiting in queue
+type QueueStatusResponse struct {
+	Queue          string `json:"queue"`
+	PlayersWaiting int    `json:"playersWaiting"`
+	WaitingTime    int64  `json:"waitingTime"`   // Seconds since joining
+	EstimatedWait  int64  `json:"estimatedWait"` // Expected total wait in seconds, -1 when unknown
+}
+
 type PaymentResponse struct {
 	MatchId    string          `json:"matchId"`
 	ExpiryTime int64           `json:"expiryTime"`
@@ -68,18 +99,12 @@ type PaymentResponse struct {
 type EventType string
 
 const (
-	Info       EventType = "INFO"
-	Error      EventType = "ERROR"
-	Success    EventType = "SUCCESS"
-	Removed    EventType = "REMOVED_FROM_QUEUE"
-	MatchState EventType = "MATCH_STATE"
+	Info        EventType = "INFO"
+	Error       EventType = "ERROR"
+	Success     EventType = "SUCCESS"
+	Removed     EventType = "REMOVED_FROM_QUEUE"
+	MatchState  EventType = "MATCH_STATE"
+	MapVeto     EventType = "MAP_VETO"
+	LobbyInfo   EventType = "LOBBY_INFO"
+	QueueStatus EventType = "QUEUE_STATUS"
 )
-
-type Message struct {
-	EventType EventType `json:"eventType"`
-	Message   string    `json:"message"`
-}
-
-func GetMessage(event EventType, message string) Message {
-	return Message{EventType: event, Message: message}
-}
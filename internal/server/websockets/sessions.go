@@ -0,0 +1,208 @@
+package ws
+
+import (
+	"mmf/internal/constants"
+	"mmf/internal/model"
+	"mmf/internal/wires"
+	"sync"
+)
+
+// Sessions a user may have open at once, e.g. desktop and phone
+const maxSessionsPerUser = 5
+
+// userSessions groups every open connection of a user.
+//
+// Any session may act for the user. Actions are handled one at a time per
+// user so concurrent requests from different sessions are decided by the
+// usual state checks, the first one wins and the others get an error. The
+// outcome is pushed to the other sessions so they stay in sync.
+type userSessions struct {
+	// Held while a session handles a client request
+	actionMutex sync.Mutex
+
+	connections map[*Connection]struct{}
+	// Ticket data of the user per queue, shared by the sessions on that queue
+	memberData map[string]*model.MemberData
+}
+
+// Map for user connections
+var userConnections = make(map[string]*userSessions)
+var userConnectionsMutex sync.Mutex
+
+// addSession registers the connection, false if the user has too many sessions open
+func addSession(conn *Connection) (*userSessions, bool) {
+	userConnectionsMutex.Lock()
+	defer userConnectionsMutex.Unlock()
+
+	sessions := userConnections[conn.UserId]
+	if sessions == nil {
+		sessions = &userSessions{
+			connections: make(map[*Connection]struct{}),
+			memberData:  make(map[string]*model.MemberData),
+		}
+		userConnections[conn.UserId] = sessions
+	}
+
+	if len(sessions.connections) >= maxSessionsPerUser {
+		return nil, false
+	}
+
+	sessions.connections[conn] = struct{}{}
+	return sessions, true
+}
+
+// removeSession unregisters the connection and reports whether it was the
+// last session of the user on its queue
+func removeSession(conn *Connection) bool {
+	userConnectionsMutex.Lock()
+	defer userConnectionsMutex.Unlock()
+
+	sessions := userConnections[conn.UserId]
+	if sessions == nil {
+		return true
+	}
+
+	delete(sessions.connections, conn)
+	if len(sessions.connections) == 0 {
+		delete(userConnections, conn.UserId)
+		return true
+	}
+
+	for other := range sessions.connections {
+		if other.Queue == conn.Queue {
+			return false
+		}
+	}
+	return true
+}
+
+func getUserConnections(id string) []*Connection {
+	userConnectionsMutex.Lock()
+	defer userConnectionsMutex.Unlock()
+
+	sessions := userConnections[id]
+	if sessions == nil {
+		return nil
+	}
+
+	connections := make([]*Connection, 0, len(sessions.connections))
+	for conn := range sessions.connections {
+		connections = append(connections, conn)
+	}
+	return connections
+}
+
+// notifyOtherSessions tells the user's other sessions about an action taken on conn
+func notifyOtherSessions(conn *Connection, eventType EventType, message interface{}) {
+	for _, other := range getUserConnections(conn.UserId) {
+		if other != conn {
+			SendJSON(other, eventType, message)
+		}
+	}
+}
+
+func (s *userSessions) getMemberData(queue string) *model.MemberData {
+	userConnectionsMutex.Lock()
+	defer userConnectionsMutex.Unlock()
+
+	return s.memberData[queue]
+}
+
+func (s *userSessions) setMemberData(queue string, memberData *model.MemberData) {
+	userConnectionsMutex.Lock()
+	defer userConnectionsMutex.Unlock()
+
+	if memberData == nil {
+		delete(s.memberData, queue)
+		return
+	}
+	s.memberData[queue] = memberData
+}
+
+// restoreSession sends the state of a player reconnecting mid match
+func restoreSession(conn *Connection, sessions *userSessions) {
+	userState := GetUserState(conn.UserId)
+	if isUserInMM(userState) {
+		// TODO: Include both the teams info
+		SendJSON(conn, MatchState, *userState)
+	} else {
+		SendJSON(conn, MatchState, nil)
+	}
+
+	// TODO: Remove the use of memberData as this is only set when user joins the queue
+	// if user gets disconnected, memberData is getting reset
+	// Using id and walletAddress of the user
+	if userState.State != model.NoState && userState.MemberData != nil && sessions.getMemberData(conn.Queue) == nil {
+		sessions.setMemberData(conn.Queue, userState.MemberData)
+	}
+}
+
+// closeSession unregisters the connection, the ticket stays while another
+// session of the user is on the queue
+func closeSession(conn *Connection, sessions *userSessions) {
+	sessions.actionMutex.Lock()
+	defer sessions.actionMutex.Unlock()
+
+	if removeSession(conn) && conn.Queue != "" {
+		sessions.setMemberData(conn.Queue, nil)
+		wires.Instance.TicketService.DeleteTicket(conn.Queue, conn.UserId)
+	}
+}
+
+func getSessions(id string) *userSessions {
+	userConnectionsMutex.Lock()
+	defer userConnectionsMutex.Unlock()
+
+	return userConnections[id]
+}
+
+func hasQueueSession(id string, queue string) bool {
+	for _, conn := range getUserConnections(id) {
+		if conn.Queue == queue {
+			return true
+		}
+	}
+	return false
+}
+
+// queueSessionUsers returns the users with a session open on each queue, a
+// session not scoped to a queue counts for every queue
+func queueSessionUsers() map[string][]string {
+	userConnectionsMutex.Lock()
+	defer userConnectionsMutex.Unlock()
+
+	users := make(map[string][]string)
+	for userId, sessions := range userConnections {
+		seen := make(map[string]bool)
+		for conn := range sessions.connections {
+			queues := []string{conn.Queue}
+			if conn.Queue == "" {
+				queues = queues[:0]
+				for _, queueType := range constants.GetAllQueueTypes() {
+					queues = append(queues, queueType.String())
+				}
+			}
+			for _, queue := range queues {
+				if !seen[queue] {
+					seen[queue] = true
+					users[queue] = append(users[queue], userId)
+				}
+			}
+		}
+	}
+	return users
+}
+
+// IsConnected reports whether the user has a session open
+func IsConnected(id string) bool {
+	return len(getUserConnections(id)) > 0
+}
+
+// RemoveFromQueue tells the user's sessions that their ticket was removed
+// without them leaving, e.g. by an operator
+func RemoveFromQueue(id string, queue string, message string) {
+	if sessions := getSessions(id); sessions != nil {
+		sessions.setMemberData(queue, nil)
+	}
+	SendMessageToUser(id, Removed, message)
+}
@@ -5,14 +5,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math/big"
 	"mmf/config"
+	"mmf/internal/metrics"
 	"net/http"
 	"strings"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 )
 
 const abiJSON = `[{
@@ -39,76 +42,86 @@ const abiJSON = `[{
 	"type": "function"
 }]`
 
-func checkTransactionOnChain(userConfirmation *UserPayment, userId string) bool {
+// checkTransactionOnChain verifies the joinMatch transaction of the payment and
+// returns the amount staked in base units of the collateral token
+func checkTransactionOnChain(userConfirmation *UserPayment, userId string) (string, bool) {
 	txHash := common.HexToHash(userConfirmation.TxnHash)
 
-	client, err := ethclient.Dial(config.GlobalConfig.EthRpc.URL)
+	rpcClient, err := rpc.DialOptions(context.Background(), config.GlobalConfig.EthRpc.URL, rpc.WithHTTPClient(metrics.HTTPClient("eth_rpc")))
 	if err != nil {
-		log.Println("Error connecting to eth client: ", err)
-		return false
+		slog.Error("Error connecting to eth client", "error", err)
+		return "", false
 	}
+	client := ethclient.NewClient(rpcClient)
+	defer client.Close()
 
 	tx, _, err := client.TransactionByHash(context.Background(), txHash)
 	if err != nil {
-		log.Println("Error getting transaction by hash: ", err)
-		return false
+		slog.Warn("Error getting transaction by hash", "txHash", userConfirmation.TxnHash, "error", err)
+		return "", false
 	}
 
 	if tx == nil {
-		return false
+		return "", false
 	}
 
 	parsedABI, err := abi.JSON(strings.NewReader(abiJSON))
 	if err != nil {
-		log.Println("Error parsing abi: ", err)
-		return false
+		slog.Error("Error parsing abi", "error", err)
+		return "", false
 	}
 
 	data := tx.Data()
 
 	method, err := parsedABI.MethodById(data[:4])
 	if err != nil {
-		log.Println("Error getting method by id: ", err)
-		return false
+		slog.Warn("Error getting method by id", "txHash", userConfirmation.TxnHash, "error", err)
+		return "", false
 	}
 
 	params, err := method.Inputs.Unpack(data[4:])
 	if err != nil {
-		log.Println("Error unpacking data: ", err)
-		return false
+		slog.Warn("Error unpacking data", "txHash", userConfirmation.TxnHash, "error", err)
+		return "", false
 	}
 
 	if len(params) != 3 {
-		log.Println("Invalid number of parameters")
-		return false
+		slog.Warn("Invalid number of parameters", "txHash", userConfirmation.TxnHash)
+		return "", false
 	}
 
 	matchId, ok := params[0].(string)
 	if !ok {
-		log.Println("Invalid match id")
-		return false
+		slog.Warn("Invalid match id", "txHash", userConfirmation.TxnHash, "matchId", userConfirmation.MatchId)
+		return "", false
 	}
 	if matchId != userConfirmation.MatchId {
-		log.Println("Invalid match id")
-		return false
+		slog.Warn("Invalid match id", "txHash", userConfirmation.TxnHash, "matchId", userConfirmation.MatchId)
+		return "", false
 	}
 
 	id, ok := params[1].(string)
 	if !ok {
-		log.Println("Invalid username")
-		return false
+		slog.Warn("Invalid username", "txHash", userConfirmation.TxnHash, "userId", userId)
+		return "", false
 	}
 	if id != userId {
-		log.Println("Invalid username")
-		return false
+		slog.Warn("Invalid username", "txHash", userConfirmation.TxnHash, "userId", userId)
+		return "", false
 	}
 
-	return true
+	amount, ok := params[2].(*big.Int)
+	if !ok {
+		slog.Warn("Invalid amount", "txHash", userConfirmation.TxnHash)
+		return "", false
+	}
+
+	return amount.String(), true
 }
 
 func idToApiKey(userId string) (*ShowdownTokenResponse, error) {
 	url := fmt.Sprintf("%s/get_lichess_token?showdownUserID=%s", config.GlobalConfig.ShowdownUserService.URL, userId)
-	client := &http.Client{}
+	client := metrics.HTTPClient("showdown")
 
 	req, err := http.NewRequest("GET", url, nil)
 
@@ -149,7 +162,7 @@ func idToApiKey(userId string) (*ShowdownTokenResponse, error) {
 
 func idToWallet(userId string) (*WalletAddressResponse, error) {
 	url := fmt.Sprintf("%s/user/info_batch?showdownUserID=%s", config.GlobalConfig.ShowdownApi.URL, userId)
-	client := &http.Client{}
+	client := metrics.HTTPClient("showdown")
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
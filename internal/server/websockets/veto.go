@@ -0,0 +1,62 @@
+package ws
+
+import (
+	"errors"
+	"mmf/internal/constants"
+	"mmf/internal/model"
+	"mmf/internal/redis"
+	"slices"
+
+	goredis "github.com/go-redis/redis"
+)
+
+// banScript stores the ban only while the veto is still in the round the
+// captain was checked against, the first ban of the round wins
+//
+// KEYS: veto
+// ARGV: round, map
+var banScript = goredis.NewScript(`
+if redis.call('HGET', KEYS[1], 'round') ~= ARGV[1] then
+	return 0
+end
+return redis.call('HSETNX', KEYS[1], 'ban', ARGV[2])
+`)
+
+func GetMapVeto(matchId string) *model.MapVeto {
+	cmd := redis.RedisClient.HGet(constants.GetVetoKey(matchId), "state")
+	if cmd.Err() != nil || cmd.Val() == "" {
+		return nil
+	}
+	return model.UnmarshalMapVeto([]byte(cmd.Val()))
+}
+
+// Records the ban of the captain whose turn it is, the veto thread applies it
+func banMap(userId string, payload *UserMapBan) error {
+	veto := GetMapVeto(payload.MatchId)
+	if veto == nil {
+		return errors.New("No map veto in progress")
+	}
+
+	if veto.CurrentCaptain() != userId {
+		return errors.New("Not your turn to ban")
+	}
+
+	if !slices.Contains(veto.Remaining, payload.Map) {
+		return errors.New("Map is not in the pool")
+	}
+
+	stored, err := banScript.Run(redis.RedisClient, []string{constants.GetVetoKey(payload.MatchId)}, veto.Round, payload.Map).Int64()
+	if err != nil {
+		return err
+	}
+	if stored == 0 {
+		return errors.New("Not your turn to ban")
+	}
+	return nil
+}
+
+func SendMapVetoToPlayers(veto *model.MapVeto, matchTickets []model.Ticket) {
+	for _, ticket := range matchTickets {
+		SendJSONToUser(ticket.Member.Id, MapVeto, veto)
+	}
+}
@@ -1,21 +1,19 @@
 package ws
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"log"
-	"mmf/internal/constants"
+	"mmf/internal/audit"
+	"mmf/internal/logging"
+	"mmf/internal/metrics"
 	"mmf/internal/model"
 	"mmf/internal/redis"
 	"mmf/internal/wires"
-	"mmf/pkg/external"
 	"net/http"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"github.com/mitchellh/mapstructure"
 )
 
 var upgrader = websocket.Upgrader{
@@ -30,10 +28,6 @@ type ShowdownApiResponse struct {
 	Token string `json:"lichessToken"`
 }
 
-// Map for user connection
-var userConnections = make(map[string]*websocket.Conn)
-var userConnectionsMutex sync.Mutex
-
 func GetUserState(id string) *model.UserGlobalState {
 	state := redis.RedisClient.HGet("user_state", id)
 	if state.Err() == nil && state.Val() != "" {
@@ -65,93 +59,44 @@ func getMatchPlayerInfo(matchId, userId string) (*model.MatchPlayer, error) {
 	return matchPlayer, nil
 }
 
-func StartLichessWebSocket(game string, id string, c *gin.Context) {
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
-	if err != nil {
+// StartWebSocket serves a player of any queue, the queue handler plugs in
+// game specific rating lookup and join payload validation. A player may have
+// several sessions open, see userSessions for how they share the ticket.
+func StartWebSocket(game string, id string, walletAddress string, c *gin.Context) {
+	queueHandler := GetQueueHandler(game)
+	if queueHandler == nil {
+		c.JSON(400, gin.H{"error": "unknown queue"})
 		return
 	}
-	defer conn.Close()
-
-	// Set pong handler to reset read deadline
-	conn.SetPongHandler(func(appData string) error {
-		conn.SetReadDeadline(time.Now().Add(20 * time.Second)) // Reset read deadline when pong is received
-
-		if err := conn.WriteMessage(websocket.TextMessage, []byte("pong")); err != nil {
-			log.Println(err)
-		}
-		return nil
-	})
-
-	userState := GetUserState(id)
-	isUserInMmVar := isUserInMM(userState)
-	if isUserInMmVar {
-		// TODO: Include both the teams info
-		SendJSON(conn, MatchState, *userState)
-	} else {
-		SendJSON(conn, MatchState, nil)
-	}
-
-	// Set up ping-pong handling
-	// Send pings every 10 seconds, expecting a pong response within 5 seconds
-	go sendPings(conn)
-
-	userConnectionsMutex.Lock()
-	userConnections[id] = conn
-	userConnectionsMutex.Unlock()
-
-	// TODO: Remove the use of memberData as this is only set when user joins the queue
-	// if user gets disconnected, memberData is getting reset
-	// Using id and walletAddress of the user
-	var memberData *model.MemberData
-	if userState.State != model.NoState && userState.MemberData != nil {
-		memberData = userState.MemberData
-	}
-
-	defer func() {
-		userConnectionsMutex.Lock()
-		defer userConnectionsMutex.Unlock()
-		delete(userConnections, id)
-
-		wires.Instance.TicketService.DeleteTicket(game, id)
 
-		// if userState != nil && userState.State != model.NoState && userState.State != model.Paid {
-		// 	cmd := redis.RedisClient.HSet("user_state", id, userState.Marshal())
-		// 	if cmd.Err() != nil {
-		// 		log.Println("Error saving user state")
-		// 		log.Println(cmd.Err())
-		// 	}
-		// }
-	}()
-
-	walletAddress, err := idToWallet(id)
+	wsConn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		log.Println("Error getting wallet address")
-		log.Println(err)
-		err := conn.WriteJSON(GetMessage(Error, "Error getting wallet address"))
-		if err != nil {
-			log.Println(err)
-		}
 		return
 	}
+	conn := NewConnection(wsConn, id, game)
+	defer conn.Close()
 
-	var eloData *model.EloData
-	showdownUser, err := idToApiKey(id)
-	if err != nil {
-		log.Println("Error getting token from showdown api ")
-		log.Println(err.Error())
+	metrics.WebsocketConnections.WithLabelValues(game).Inc()
+	defer metrics.WebsocketConnections.WithLabelValues(game).Dec()
+
+	sessions, ok := addSession(conn)
+	if !ok {
+		ReplyError(conn, "", NewProtocolError(NotAllowed, "Too many sessions open"))
 		return
 	}
 
-	rating, err := external.GetGlicko(showdownUser.LichessToken, "blitz") // TODO: Make it so that elo is fetched for correct game mode
+	restoreSession(conn, sessions)
+	defer closeSession(conn, sessions)
+
+	player, err := queueHandler.Connect(id, walletAddress)
 	if err != nil {
-		log.Println("Error getting elo from lichess, using default elo 1500")
-		eloData = &model.EloData{Elo: 1500}
-	} else {
-		eloData = &model.EloData{Elo: float64(rating)}
+		logging.User(id, game).Error("Error connecting player", "error", err)
+		ReplyError(conn, "", NewProtocolError(InternalError, "Error getting player info"))
+		return
 	}
 
 	for {
-		_, mess, err := conn.ReadMessage()
+		mess, err := conn.ReadMessage()
 		stringifiedMessage := string(mess)
 		if err != nil {
 			return
@@ -161,227 +106,219 @@ func StartLichessWebSocket(game string, id string, c *gin.Context) {
 			continue
 		}
 
-		var userResponse UserMessage
-		if err = json.Unmarshal(mess, &userResponse); err != nil {
-			conn.WriteJSON(GetMessage(Error, "Error parsing message"))
+		userMessage, protocolErr := ParseClientMessage(mess)
+		if protocolErr != nil {
+			ReplyError(conn, requestIdOf(userMessage), protocolErr)
 			continue
 		}
 
-		userState := GetUserState(id)
-
-		switch userResponse.Type {
-		case JoinQueue:
-			// TODO: Add check to prevent user from joining the same queue multiple times
-			var payload []model.LichessCustomData
-			if err := mapstructure.Decode(userResponse.Payload, &payload); err != nil || payload == nil {
-				conn.WriteJSON(GetMessage(Error, "Error parsing payload"))
-				continue
-			}
-
-			if length := len(payload); length == 0 || length > 3 {
-				conn.WriteJSON(GetMessage(Error, "Invalid payload, must be between 1 and 3"))
-				continue
-			}
-
-			if isUserInMM(userState) {
-				SendJSON(conn, Error, "User already part of Queue")
-				continue
-			}
-
-			for i := range payload {
-				payload[i].Timestamp = time.Now().Unix()
-			}
-
-			memberData, err = wires.Instance.TicketService.SubmitTicket(model.SubmitTicketRequest{
-				Id:                id,
-				Elo:               eloData.Elo,
-				WalletAddress:     walletAddress.WalletAddress,
-				LichessCustomData: payload,
-			}, game)
-			if err != nil {
-				conn.WriteJSON(GetMessage(Error, "Error submitting ticket"))
-				log.Println("Error submitting ticket")
-				log.Println(err.Error())
-			}
-
-			conn.WriteJSON(GetMessage(Info, "Joined queue"))
-		case LeaveQueue:
-			if err := wires.Instance.TicketService.DeleteTicket(game, id); err != nil {
-				log.Println("error leaving queue", err)
-				conn.WriteJSON(GetMessage(Error, "Error leaving queue"))
-				continue
-			}
-
-			conn.WriteJSON(GetMessage(Info, "Left queue"))
-		case SendPayment:
-			// TODO: Add event validation
-			var payload *UserPayment
-			if err := mapstructure.Decode(userResponse.Payload, &payload); err != nil || payload == nil {
-				conn.WriteJSON(GetMessage(Error, "Error parsing payload"))
-				continue
-			}
-
-			paid := checkTransactionOnChain(payload, showdownUser.LichessId)
-			if !paid {
-				conn.WriteJSON(GetMessage(Error, "Error processing payment"))
-				continue
-			}
-
-			matchPlayer, err := getMatchPlayerInfo(payload.MatchId, id)
-			if err != nil {
-				conn.WriteJSON(GetMessage(Error, "Error getting match player"))
-				continue
-			}
-
-			log.Printf("Player %s has Paid for Match: %s\n", id, payload.MatchId)
-
-			matchPlayer.Paid = true
-			redis.RedisClient.HSet(payload.MatchId, id, matchPlayer.Marshal())
-			conn.WriteJSON(GetMessage(Info, "Payment processed"))
-			userState.State = model.Paid
-			userState.MatchId = payload.MatchId
-			userState.MemberData = memberData
-			redis.RedisClient.HSet("user_state", id, userState.Marshal())
-		case SendOption:
-			// TODO: Add event validation
-			var payload *UserResponse
-			if err := mapstructure.Decode(userResponse.Payload, &payload); err != nil || payload == nil {
-				conn.WriteJSON(GetMessage(Error, "Error parsing payload"))
-				continue
-			}
-
-			matchPlayer, err := getMatchPlayerInfo(payload.MatchId, id)
-			if err != nil {
-				conn.WriteJSON(GetMessage(Error, "Error getting match player"))
-				continue
-			}
-
-			matchPlayer.Option = payload.Option
-			redis.RedisClient.HSet(payload.MatchId, id, matchPlayer.Marshal())
-			conn.WriteJSON(GetMessage(Info, "Send option successful"))
-			if payload.Option == 2 {
-				userState.State = model.MatchAccepted
-				userState.MatchId = payload.MatchId
-				userState.MemberData = memberData
-				redis.RedisClient.HSet("user_state", id, userState.Marshal())
-			} else {
-				redis.RedisClient.HDel(payload.MatchId, id)
-			}
-		default:
-			conn.WriteJSON(GetMessage(Error, "Invalid message type"))
-		}
+		sessions.actionMutex.Lock()
+		handleClientMessage(conn, sessions, queueHandler, player, userMessage)
+		sessions.actionMutex.Unlock()
 	}
 
 }
 
-func StartWebSocket(game string, steamId string, walletAddress string, c *gin.Context) {
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
-	if err != nil {
-		return
-	}
-	defer conn.Close()
+// handleClientMessage runs a request of one of the user's sessions, the
+// caller holds the user's action lock
+func handleClientMessage(conn *Connection, sessions *userSessions, queueHandler QueueHandler, player *Player, userMessage *ClientMessage) {
+	id := conn.UserId
+	game := conn.Queue
+	requestId := userMessage.RequestId
+	logger := logging.User(id, game).With("requestId", requestId)
+
+	userState := GetUserState(id)
 
-	userConnectionsMutex.Lock()
-	userConnections[steamId] = conn
-	userConnectionsMutex.Unlock()
-
-	var memberData *model.MemberData
-	defer func() {
-		userConnectionsMutex.Lock()
-		defer userConnectionsMutex.Unlock()
-		delete(userConnections, steamId)
-		if memberData != nil {
-			memberJSON, err := json.Marshal(memberData)
-			if err != nil {
-				log.Println("Error serializing MemberData:", err)
-				return
-			}
-
-			redis.RedisClient.ZRem(constants.GetIndexNameStr(game), memberJSON)
+	switch userMessage.Type {
+	case JoinQueue:
+		var joinPayload JoinQueuePayload
+		if protocolErr := userMessage.DecodePayload(&joinPayload); protocolErr != nil {
+			ReplyError(conn, requestId, protocolErr)
+			return
 		}
-	}()
 
-	var eloData *model.EloData
-	switch game {
-	case "lcqueue":
-		apiKey, err := idToApiKey(steamId)
+		if isUserInMM(userState) || wires.Instance.TicketService.GetTicket(game, id) != nil {
+			ReplyError(conn, requestId, NewProtocolError(AlreadyInQueue, "User already part of Queue"))
+			return
+		}
 
+		ticket, err := queueHandler.BuildTicket(player, &joinPayload)
 		if err != nil {
-			log.Println("Error getting token from showdown api ")
-			log.Println(err.Error())
+			ReplyError(conn, requestId, NewProtocolError(InvalidPayload, err.Error()))
 			return
 		}
 
-		rating, err := external.GetGlicko(apiKey.LichessToken, "blitz") // TODO: Make it so that elo is fetched for correct game mode
+		memberData, err := wires.Instance.TicketService.SubmitTicket(context.Background(), *ticket, game)
 		if err != nil {
-			log.Println("Error getting elo from lichess, using default elo 1500")
-			eloData = &model.EloData{Elo: 1500}
-		} else {
-			eloData = &model.EloData{Elo: float64(rating)}
+			ReplyError(conn, requestId, NewProtocolError(InternalError, "Error submitting ticket"))
+			logger.Error("Error submitting ticket", "error", err)
+			return
 		}
 
-	default:
-		eloData = external.GetDataFromRelay(steamId)
-	}
+		sessions.setMemberData(game, memberData)
+		Reply(conn, requestId, Info, "Joined queue")
+		notifyOtherSessions(conn, Info, "Joined queue")
+	case LeaveQueue:
+		if isUserInMM(userState) {
+			ReplyError(conn, requestId, NewProtocolError(NotAllowed, "Can't leave queue during a match"))
+			return
+		}
 
-	memberData, err = wires.Instance.TicketService.SubmitTicket(model.SubmitTicketRequest{
-		Id:            steamId,
-		Elo:           eloData.Elo,
-		WalletAddress: walletAddress,
-	}, game)
-	if err != nil {
-		conn.WriteJSON(GetMessage(Error, "Error submitting ticket"))
-		log.Println("Error submitting ticket")
-		log.Println(err.Error())
-		return
-	}
+		if err := wires.Instance.TicketService.DeleteTicket(game, id); err != nil {
+			logger.Warn("Error leaving queue", "error", err)
+			ReplyError(conn, requestId, NewProtocolError(NotInQueue, "Error leaving queue"))
+			return
+		}
 
-	conn.WriteJSON(GetMessage(Info, "Hello, "+steamId))
-	waitForNewMsg := true
-	for {
-		_, mess, err := conn.ReadMessage()
+		sessions.setMemberData(game, nil)
+		Reply(conn, requestId, Info, "Left queue")
+		notifyOtherSessions(conn, Info, "Left queue")
+	case ReportPing:
+		var payload UserPings
+		if protocolErr := userMessage.DecodePayload(&payload); protocolErr != nil {
+			ReplyError(conn, requestId, protocolErr)
+			return
+		}
+
+		if err := validatePings(payload.Pings); err != nil {
+			ReplyError(conn, requestId, NewProtocolError(InvalidPayload, err.Error()))
+			return
+		}
+
+		updatedMemberData, err := wires.Instance.TicketService.UpdateTicketPings(game, id, payload.Pings)
 		if err != nil {
+			logger.Warn("Error updating pings", "error", err)
+			ReplyError(conn, requestId, NewProtocolError(NotInQueue, "Error updating pings"))
 			return
 		}
 
-		if !waitForNewMsg {
-			continue
+		sessions.setMemberData(game, updatedMemberData)
+		Reply(conn, requestId, Info, "Pings updated")
+	case SendOption:
+		var payload UserResponse
+		if protocolErr := userMessage.DecodePayload(&payload); protocolErr != nil {
+			ReplyError(conn, requestId, protocolErr)
+			return
+		}
+
+		if payload.Option != 0 && payload.Option != 2 {
+			ReplyError(conn, requestId, NewProtocolError(InvalidPayload, "Option must be 0 or 2"))
+			return
+		}
+		if protocolErr := validateMatchId(payload.MatchId); protocolErr != nil {
+			ReplyError(conn, requestId, protocolErr)
+			return
 		}
 
-		var userResponse UserResponse
-		if err = json.Unmarshal(mess, &userResponse); err != nil || userResponse.Option == 0 {
-			log.Println(err)
+		matchPlayer, err := getMatchPlayerInfo(payload.MatchId, id)
+		if err != nil {
+			ReplyError(conn, requestId, NewProtocolError(MatchNotFound, "Error getting match player"))
+			return
+		}
+
+		if matchPlayer.Option != 1 {
+			ReplyError(conn, requestId, NewProtocolError(NotAllowed, "Option already sent"))
+			return
+		}
+
+		// Declines are kept in the match so the match thread can react to them
+		matchPlayer.Option = payload.Option
+		redis.RedisClient.HSet(payload.MatchId, id, matchPlayer.Marshal())
+		if payload.Option == 2 {
+			metrics.MatchResponses.WithLabelValues(game, metrics.ResponseAccept).Inc()
+			audit.Record(audit.Entry{Type: audit.PlayerAccepted, Queue: game, MatchId: payload.MatchId, UserId: id})
+		} else {
+			metrics.MatchResponses.WithLabelValues(game, metrics.ResponseDecline).Inc()
+			audit.Record(audit.Entry{Type: audit.PlayerDeclined, Queue: game, MatchId: payload.MatchId, UserId: id})
+		}
+		Reply(conn, requestId, Info, "Send option successful")
+		if payload.Option == 2 {
+			userState.State = model.MatchAccepted
+			userState.MatchId = payload.MatchId
+			userState.MemberData = sessions.getMemberData(game)
+			UpdateUserState(id, userState)
+			notifyOtherSessions(conn, MatchState, *userState)
+		} else {
+			notifyOtherSessions(conn, Info, "Match declined")
+		}
+	case SendPayment:
+		var payload UserPayment
+		if protocolErr := userMessage.DecodePayload(&payload); protocolErr != nil {
+			ReplyError(conn, requestId, protocolErr)
+			return
+		}
+		if protocolErr := validateMatchId(payload.MatchId); protocolErr != nil {
+			ReplyError(conn, requestId, protocolErr)
+			return
+		}
 
-			var userConfirmation UserPayment
+		matchPlayer, err := getMatchPlayerInfo(payload.MatchId, id)
+		if err != nil {
+			ReplyError(conn, requestId, NewProtocolError(MatchNotFound, "Error getting match player"))
+			return
+		}
 
-			if err = json.Unmarshal(mess, &userConfirmation); err != nil {
-				log.Println(err)
-				return
-			}
-			redisPlayer := redis.RedisClient.HGet(userConfirmation.MatchId, steamId).Val()
-			matchPlayer := model.UnmarshalMatchPlayer([]byte(redisPlayer))
+		if matchPlayer.Option != 2 {
+			ReplyError(conn, requestId, NewProtocolError(NotAllowed, "Match was not accepted"))
+			return
+		}
 
-			if matchPlayer.Option != 2 {
-				//match declined
-				return
-			}
+		if matchPlayer.Paid {
+			ReplyError(conn, requestId, NewProtocolError(NotAllowed, "Match already paid"))
+			return
+		}
 
-			matchPlayer.TxnHash = userConfirmation.TxnHash
-			matchPlayer.Paid = checkTransactionOnChain(&userConfirmation, steamId)
-			if !matchPlayer.Paid {
-				continue
-			}
-			redis.RedisClient.HSet(userResponse.MatchId, steamId, matchPlayer.Marshal())
+		verificationStart := time.Now()
+		amount, paymentVerified := checkTransactionOnChain(&payload, player.PaymentId)
+		metrics.ObservePaymentVerification(game, verificationStart, paymentVerified)
+		if !paymentVerified {
+			audit.Record(audit.Entry{Type: audit.PaymentRejected, Queue: game, MatchId: payload.MatchId, UserId: id,
+				Details: map[string]interface{}{"txHash": payload.TxnHash}})
+			ReplyError(conn, requestId, NewProtocolError(PaymentFailed, "Error processing payment"))
+			return
+		}
 
-			waitForNewMsg = false
-			continue
+		logger.Info("Player has paid for match", "matchId", payload.MatchId)
+		audit.Record(audit.Entry{Type: audit.PlayerPaid, Queue: game, MatchId: payload.MatchId, UserId: id,
+			Details: map[string]interface{}{"txHash": payload.TxnHash, "amount": amount, "source": "client"}})
+
+		matchPlayer.Paid = true
+		matchPlayer.TxnHash = payload.TxnHash
+		matchPlayer.Amount = amount
+		redis.RedisClient.HSet(payload.MatchId, id, matchPlayer.Marshal())
+		Reply(conn, requestId, Info, "Payment processed")
+		userState.State = model.Paid
+		userState.MatchId = payload.MatchId
+		userState.MemberData = sessions.getMemberData(game)
+		UpdateUserState(id, userState)
+		notifyOtherSessions(conn, MatchState, *userState)
+	case BanMap:
+		// Map bans are sent by captains after payment
+		var payload UserMapBan
+		if protocolErr := userMessage.DecodePayload(&payload); protocolErr != nil {
+			ReplyError(conn, requestId, protocolErr)
+			return
+		}
+		if protocolErr := validateMatchId(payload.MatchId); protocolErr != nil {
+			ReplyError(conn, requestId, protocolErr)
+			return
 		}
 
-		redisPlayer := redis.RedisClient.HGet(userResponse.MatchId, steamId).Val()
-		matchPlayer := model.UnmarshalMatchPlayer([]byte(redisPlayer))
+		if err := banMap(id, &payload); err != nil {
+			ReplyError(conn, requestId, NewProtocolError(NotAllowed, err.Error()))
+			return
+		}
 
-		matchPlayer.Option = userResponse.Option
-		redis.RedisClient.HSet(userResponse.MatchId, steamId, matchPlayer.Marshal())
+		Reply(conn, requestId, Info, "Map banned")
+	default:
+		ReplyError(conn, requestId, NewProtocolError(UnknownType, "Invalid message type"))
 	}
+}
 
+// Request id of a message that failed to parse, if it got that far
+func requestIdOf(message *ClientMessage) string {
+	if message == nil {
+		return ""
+	}
+	return message.RequestId
 }
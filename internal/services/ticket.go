@@ -1,14 +1,20 @@
 package services
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"mmf/config"
+	"mmf/internal/audit"
+	"mmf/internal/clock"
 	"mmf/internal/constants"
 	"mmf/internal/model"
+	"mmf/internal/tracing"
+	"strconv"
 
 	"github.com/go-redis/redis"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type TicketServiceImpl struct {
@@ -16,77 +22,363 @@ type TicketServiceImpl struct {
 	MMRConfig config.MMRConfig
 }
 
-func (s *TicketServiceImpl) SubmitTicket(submitTicketRequest model.SubmitTicketRequest, queue string) (*model.MemberData, error) {
+func (s *TicketServiceImpl) SubmitTicket(ctx context.Context, submitTicketRequest model.SubmitTicketRequest, queue string) (*model.MemberData, error) {
+	ctx, span := tracing.Start(ctx, "ticket.submit", attribute.String("queue", queue), attribute.String("userId", submitTicketRequest.Id))
+	defer span.End()
+
+	joinedAt := submitTicketRequest.JoinedAt
+	if joinedAt == 0 {
+		joinedAt = clock.Now().Unix()
+	}
+
 	memberData := &model.MemberData{
+		TicketId:          uuid.Must(uuid.NewV7()).String(),
 		WalletAddress:     submitTicketRequest.WalletAddress,
 		Id:                submitTicketRequest.Id,
 		LichessCustomData: submitTicketRequest.LichessCustomData,
+		Regions:           submitTicketRequest.Regions,
+		Pings:             submitTicketRequest.Pings,
+		Roles:             submitTicketRequest.Roles,
+		JoinedAt:          joinedAt,
+		TraceParent:       tracing.TraceParent(ctx),
+		NoExpiry:          submitTicketRequest.NoExpiry,
 	}
-	resp := s.Redis.ZAdd(constants.GetIndexNameStr(queue), redis.Z{Score: float64(submitTicketRequest.Elo), Member: memberData})
-	if resp.Err() != nil {
-		log.Println("Error adding ticket", resp.Err())
-		return nil, resp.Err()
+	ticket := &model.Ticket{Member: *memberData, Score: float64(submitTicketRequest.Elo)}
+
+	// Ticket is added to the queue and to every pool it accepts in one script,
+	// a previous ticket of the user is replaced, not left behind in the queue
+	if _, err := s.addTicket(queue, ticket, true); err != nil {
+		slog.Error("Error adding ticket", "userId", submitTicketRequest.Id, "queue", queue, "error", err)
+		tracing.Fail(span, err.Error())
+		return nil, err
 	}
 
+	audit.Record(audit.Entry{Type: audit.TicketEnqueued, Queue: queue, UserId: memberData.Id, Details: map[string]interface{}{
+		"ticketId":           memberData.TicketId,
+		"elo":                submitTicketRequest.Elo,
+		"regions":            memberData.Regions,
+		"pings":              memberData.Pings,
+		"roles":              memberData.Roles,
+		"lichessPreferences": memberData.LichessCustomData,
+	}})
+
 	return memberData, nil
 }
 
 func (s *TicketServiceImpl) GetAllTickets(queue string) *[]model.Ticket {
-	tickets, err := s.Redis.ZRangeWithScores(constants.GetIndexNameStr(queue), 0, -1).Result() // Includes second limit
+	return s.getTickets(queue, constants.GetIndexNameStr(queue))
+}
+
+// GetPoolTickets returns tickets of a single pool sorted by score
+func (s *TicketServiceImpl) GetPoolTickets(queue string, poolKey string) *[]model.Ticket {
+	return s.getTickets(queue, constants.GetPoolIndexName(queue, poolKey))
+}
+
+// GetPoolKeys returns keys of all non empty pools of the queue
+func (s *TicketServiceImpl) GetPoolKeys(queue string) []string {
+	poolKeys, err := s.Redis.SMembers(constants.GetPoolSetName(queue)).Result()
 	if err != nil {
-		log.Println("Error fetching tickets", err)
+		slog.Error("Error fetching pools", "queue", queue, "error", err)
 		return nil
 	}
-	var gameTickets []model.Ticket
-	for _, ticket := range tickets {
-		ticketStr, ok := ticket.Member.(string)
-		if !ok {
-			log.Println("ticket is not a string")
+
+	nonEmpty := make([]string, 0, len(poolKeys))
+	for _, poolKey := range poolKeys {
+		if s.Redis.Exists(constants.GetPoolIndexName(queue, poolKey)).Val() == 0 {
+			s.Redis.SRem(constants.GetPoolSetName(queue), poolKey)
 			continue
 		}
+		nonEmpty = append(nonEmpty, poolKey)
+	}
 
-		var gameTicketMemberData model.MemberData
-		err := json.Unmarshal([]byte(ticketStr), &gameTicketMemberData)
-		if err != nil {
-			log.Printf("Error unmarshaling member data: %s\n", err)
+	return nonEmpty
+}
+
+// getTickets loads the tickets of the sorted set, members are ticket ids and
+// the attributes of all tickets are fetched in one round trip
+func (s *TicketServiceImpl) getTickets(queue string, key string) *[]model.Ticket {
+	members, err := s.Redis.ZRangeWithScores(key, 0, -1).Result() // Includes second limit
+	if err != nil {
+		slog.Error("Error fetching tickets", "key", key, "error", err)
+		return nil
+	}
+
+	pipe := s.Redis.Pipeline()
+	attributes := make([]*redis.StringStringMapCmd, 0, len(members))
+	for _, member := range members {
+		ticketId, _ := member.Member.(string)
+		attributes = append(attributes, pipe.HGetAll(constants.GetTicketKey(ticketId)))
+	}
+	if _, err := pipe.Exec(); err != nil {
+		slog.Error("Error fetching ticket attributes", "key", key, "error", err)
+		return nil
+	}
+
+	gameTickets := make([]model.Ticket, 0, len(members))
+	orphans := []interface{}{}
+	for i, member := range members {
+		fields := attributes[i].Val()
+		if len(fields) == 0 {
+			if migrated, legacy := s.migrateLegacyTicket(queue, key, member); legacy {
+				if migrated != nil {
+					gameTickets = append(gameTickets, *migrated)
+				}
+				continue
+			}
+
+			// Tickets are added and removed atomically, a member without
+			// attributes is a leftover and only gets in the way
+			orphans = append(orphans, member.Member)
 			continue
 		}
 
-		gameTicket := model.Ticket{Member: gameTicketMemberData, Score: ticket.Score}
+		memberData, err := parseTicketFields(fields)
+		if err != nil {
+			slog.Error("Error reading ticket attributes", "key", key, "ticketId", member.Member, "error", err)
+			continue
+		}
+		gameTickets = append(gameTickets, model.Ticket{Member: *memberData, Score: member.Score})
+	}
 
-		gameTickets = append(gameTickets, gameTicket)
+	if len(orphans) != 0 {
+		slog.Warn("Removing tickets without attributes", "key", key, "count", len(orphans))
+		s.Redis.ZRem(key, orphans...)
 	}
 	return &gameTickets
 }
 
+// GetTicket returns ticket of the user or nil if the user is not in the queue
+func (s *TicketServiceImpl) GetTicket(queue string, userId string) *model.Ticket {
+	ticketId, err := s.Redis.HGet(constants.GetTicketIndexName(queue), userId).Result()
+	if err != nil {
+		if err != redis.Nil {
+			slog.Error("Error fetching ticket id", "userId", userId, "queue", queue, "error", err)
+		}
+		return nil
+	}
+
+	pipe := s.Redis.Pipeline()
+	score := pipe.ZScore(constants.GetIndexNameStr(queue), ticketId)
+	attributes := pipe.HGetAll(constants.GetTicketKey(ticketId))
+	if _, err := pipe.Exec(); err != nil {
+		if err != redis.Nil {
+			slog.Error("Error fetching ticket", "userId", userId, "queue", queue, "error", err)
+		}
+		return nil
+	}
+	if len(attributes.Val()) == 0 {
+		return nil
+	}
+
+	memberData, err := parseTicketFields(attributes.Val())
+	if err != nil {
+		slog.Error("Error reading ticket attributes", "userId", userId, "ticketId", ticketId, "error", err)
+		return nil
+	}
+	return &model.Ticket{Member: *memberData, Score: score.Val()}
+}
+
 func (s *TicketServiceImpl) DeleteTicket(queue string, userId string) error {
-	tickets := s.GetAllTickets(queue)
+	ticket := s.GetTicket(queue, userId)
+	if ticket == nil {
+		return nil
+	}
 
-	if tickets == nil {
-		err := fmt.Errorf("couldn't delete ticket as tickets are empty")
+	removed, err := s.RemoveTickets(queue, []model.MemberData{ticket.Member})
+	if err != nil || len(removed) == 0 {
 		return err
 	}
+	audit.Record(audit.Entry{Type: audit.TicketCancelled, Queue: queue, UserId: userId})
+	return nil
+}
+
+// UpdateTicketPings sets the reported pings on the ticket of the user
+func (s *TicketServiceImpl) UpdateTicketPings(queue string, userId string, pings map[string]int) (*model.MemberData, error) {
+	return s.updateTicket(queue, userId, &model.MemberData{Pings: pings}, "pings")
+}
+
+// RemoveTickets removes tickets from the queue and all of their pools
+// atomically, it returns the tickets that were still in the queue
+func (s *TicketServiceImpl) RemoveTickets(queue string, members []model.MemberData) ([]model.MemberData, error) {
+	pipe := s.Redis.TxPipeline()
+	removing := make([]model.MemberData, 0, len(members))
+	results := make([]*redis.Cmd, 0, len(members))
+	for i := range members {
+		member := &members[i]
+		if member.TicketId == "" {
+			// Ticket id is looked up for members built from other sources
+			ticket := s.GetTicket(queue, member.Id)
+			if ticket == nil {
+				continue
+			}
+			member = &ticket.Member
+		}
+		removing = append(removing, *member)
+		results = append(results, s.removeTicket(pipe, queue, member))
+	}
+	if len(removing) == 0 {
+		return nil, nil
+	}
 
-	var memberData model.MemberData
+	if _, err := pipe.Exec(); err != nil {
+		err := fmt.Errorf("error removing ticket from queue - %s", err)
+		return nil, err
+	}
 
+	removed := make([]model.MemberData, 0, len(removing))
+	for i, result := range results {
+		if count, _ := result.Int64(); count > 0 {
+			removed = append(removed, removing[i])
+		}
+	}
+	return removed, nil
+}
+
+// ClaimTickets takes the tickets out of the queue for a match, all of them or
+// none. It reports false when a ticket was claimed by another match or
+// removed meanwhile.
+func (s *TicketServiceImpl) ClaimTickets(queue string, members []model.MemberData) (bool, error) {
+	claiming := make([]model.MemberData, 0, len(members))
+	for _, member := range members {
+		if member.TicketId == "" {
+			// Ticket id is looked up for members built from other sources
+			ticket := s.GetTicket(queue, member.Id)
+			if ticket == nil {
+				return false, nil
+			}
+			member = ticket.Member
+		}
+		claiming = append(claiming, member)
+	}
+	if len(claiming) == 0 {
+		return true, nil
+	}
+
+	return s.claimTickets(queue, claiming)
+}
+
+// ClearQueue removes every ticket of the queue together with its pools
+func (s *TicketServiceImpl) ClearQueue(queue string) error {
+	keys := []string{
+		constants.GetIndexNameStr(queue),
+		constants.GetPoolSetName(queue),
+		constants.GetHeartbeatSetName(queue),
+		constants.GetTicketIndexName(queue),
+	}
+	for _, poolKey := range s.Redis.SMembers(constants.GetPoolSetName(queue)).Val() {
+		keys = append(keys, constants.GetPoolIndexName(queue, poolKey))
+	}
+	for _, ticketId := range s.Redis.ZRange(constants.GetIndexNameStr(queue), 0, -1).Val() {
+		keys = append(keys, constants.GetTicketKey(ticketId))
+	}
+
+	return s.Redis.Del(keys...).Err()
+}
+
+// RefreshTickets extends the life of the tickets of the users, users without
+// a ticket in the queue are skipped
+func (s *TicketServiceImpl) RefreshTickets(queue string, userIds []string) error {
+	if s.MMRConfig.TicketTTL <= 0 || len(userIds) == 0 {
+		return nil
+	}
+
+	expiry := s.heartbeatExpiry()
+	members := make([]redis.Z, 0, len(userIds))
+	for _, userId := range userIds {
+		members = append(members, redis.Z{Score: expiry, Member: userId})
+	}
+	return s.Redis.ZAddXX(constants.GetHeartbeatSetName(queue), members...).Err()
+}
+
+// FilterLiveTickets drops tickets whose owner stopped sending heartbeats, on
+// error the tickets are returned as they are and the reaper catches up later
+func (s *TicketServiceImpl) FilterLiveTickets(queue string, tickets []model.Ticket) []model.Ticket {
+	if s.MMRConfig.TicketTTL <= 0 {
+		return tickets
+	}
+
+	live, err := s.liveUserIds(queue)
+	if err != nil {
+		slog.Error("Error fetching ticket heartbeats", "queue", queue, "error", err)
+		return tickets
+	}
+
+	liveTickets := make([]model.Ticket, 0, len(tickets))
+	for _, ticket := range tickets {
+		if live[ticket.Member.Id] || ticket.Member.NoExpiry {
+			liveTickets = append(liveTickets, ticket)
+		}
+	}
+	return liveTickets
+}
+
+// ExpiredTickets returns tickets of the queue whose heartbeat expired or is
+// missing, e.g. left behind by a replica that crashed
+func (s *TicketServiceImpl) ExpiredTickets(queue string) ([]model.Ticket, error) {
+	if s.MMRConfig.TicketTTL <= 0 {
+		return nil, nil
+	}
+
+	tickets := s.GetAllTickets(queue)
+	if tickets == nil {
+		return nil, fmt.Errorf("couldn't fetch tickets of queue %s", queue)
+	}
+	live, err := s.liveUserIds(queue)
+	if err != nil {
+		return nil, err
+	}
+
+	expired := []model.Ticket{}
 	for _, ticket := range *tickets {
-		if ticket.Member.Id == userId {
-			memberData = ticket.Member
-			break
+		if !live[ticket.Member.Id] && !ticket.Member.NoExpiry {
+			expired = append(expired, ticket)
 		}
 	}
+	return expired, nil
+}
 
-	marshalledMemberData, err := memberData.MarshalBinary()
+func (s *TicketServiceImpl) liveUserIds(queue string) (map[string]bool, error) {
+	now := strconv.FormatInt(clock.Now().Unix(), 10)
+	userIds, err := s.Redis.ZRangeByScore(constants.GetHeartbeatSetName(queue), redis.ZRangeBy{Min: now, Max: "+inf"}).Result()
 	if err != nil {
-		err := fmt.Errorf("couldn't delete marshal member data %s", err)
-		return err
+		return nil, err
 	}
 
-	cmd := s.Redis.ZRem(constants.GetIndexNameStr(queue), marshalledMemberData)
-	if cmd.Err() != nil {
-		err := fmt.Errorf("error removing ticket from queue - %s", cmd.Err())
-		return err
+	live := make(map[string]bool, len(userIds))
+	for _, userId := range userIds {
+		live[userId] = true
+	}
+	return live, nil
+}
+
+func (s *TicketServiceImpl) heartbeatExpiry() float64 {
+	return float64(clock.Now().Unix() + int64(s.MMRConfig.TicketTTL))
+}
+
+// PauseQueue stops the crawler from creating matches in the queue, players can still join
+func (s *TicketServiceImpl) PauseQueue(queue string) error {
+	return s.Redis.SAdd(constants.PausedQueuesSet, queue).Err()
+}
+
+func (s *TicketServiceImpl) ResumeQueue(queue string) error {
+	return s.Redis.SRem(constants.PausedQueuesSet, queue).Err()
+}
+
+func (s *TicketServiceImpl) IsQueuePaused(queue string) bool {
+	return s.Redis.SIsMember(constants.PausedQueuesSet, queue).Val()
+}
+
+// GetTicketPoolKeys returns the pools the ticket is in. Only lichess tickets
+// are split in pools, by time, increment, collateral and variant.
+func GetTicketPoolKeys(queue string, memberData *model.MemberData) []string {
+	if !constants.IsLichessQueue(queue) {
+		return nil
 	}
 
-	return nil
+	poolKeys := make([]string, 0, len(memberData.LichessCustomData))
+	for _, customData := range memberData.LichessCustomData {
+		poolKeys = append(poolKeys, customData.Key())
+	}
+
+	return poolKeys
 }
@@ -0,0 +1,371 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mmf/internal/constants"
+	"mmf/internal/model"
+	"strings"
+
+	"github.com/go-redis/redis"
+	"github.com/google/uuid"
+)
+
+// Tickets are stored as
+//   - players_<queue> and pool sorted sets, member is the ticket id, score is elo
+//   - ticket_<ticketId> hash, one JSON encoded field per attribute
+//   - tickets_<queue> hash, user id to ticket id
+//
+// Scripts only touch keys passed in KEYS, keys depending on stored data are
+// looked up by the caller first and checked again by the script.
+
+// removeScript removes the ticket from the queue and its pools and returns 1
+// if it was still in the queue. The user index and heartbeat are only dropped
+// while they still belong to this ticket, so a newer ticket of the same user
+// is left alone.
+//
+// KEYS: queue, ticket, user index, heartbeats, pools...
+// ARGV: ticket id, user id
+var removeScript = redis.NewScript(`
+local removed = redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('DEL', KEYS[2])
+for i = 5, #KEYS do
+	redis.call('ZREM', KEYS[i], ARGV[1])
+end
+if redis.call('HGET', KEYS[3], ARGV[2]) == ARGV[1] then
+	redis.call('HDEL', KEYS[3], ARGV[2])
+	redis.call('ZREM', KEYS[4], ARGV[2])
+end
+return removed
+`)
+
+// claimScript removes the tickets from the queue and their pools and returns
+// 1, when one of them is no longer in the queue nothing is removed and 0 is
+// returned.
+//
+// KEYS: queue, user index, heartbeats, then per ticket its key and pools...
+// ARGV: ticket id, user id, number of pools, per ticket
+var claimScript = redis.NewScript(`
+for i = 1, #ARGV, 3 do
+	if not redis.call('ZSCORE', KEYS[1], ARGV[i]) then
+		return 0
+	end
+end
+local key = 4
+for i = 1, #ARGV, 3 do
+	local pools = tonumber(ARGV[i + 2])
+	redis.call('ZREM', KEYS[1], ARGV[i])
+	redis.call('DEL', KEYS[key])
+	for j = key + 1, key + pools do
+		redis.call('ZREM', KEYS[j], ARGV[i])
+	end
+	key = key + 1 + pools
+	if redis.call('HGET', KEYS[2], ARGV[i + 1]) == ARGV[i] then
+		redis.call('HDEL', KEYS[2], ARGV[i + 1])
+		redis.call('ZREM', KEYS[3], ARGV[i + 1])
+	end
+end
+return 1
+`)
+
+// addScript adds the ticket to the queue and its pools and returns 1. A
+// previous ticket of the user is replaced when asked to, otherwise the ticket
+// isn't added and 0 is returned. The caller looks up the previous ticket so
+// every key is declared, -1 is returned when it changed meanwhile.
+//
+// KEYS: queue, ticket, user index, heartbeats, pool set, previous ticket,
+// pools of the ticket..., every pool of the queue...
+// ARGV: ticket id, user id, score, heartbeat expiry or 0, replace 1 or 0,
+// previous ticket id or empty, number of pools, pool keys...,
+// field, value, field, value...
+var addScript = redis.NewScript(`
+local previous = redis.call('HGET', KEYS[3], ARGV[2])
+if (previous or '') ~= ARGV[6] then
+	return -1
+end
+local pools = tonumber(ARGV[7])
+if previous then
+	if ARGV[5] ~= '1' then
+		return 0
+	end
+	redis.call('ZREM', KEYS[1], previous)
+	redis.call('DEL', KEYS[6])
+	for i = 7 + pools, #KEYS do
+		redis.call('ZREM', KEYS[i], previous)
+	end
+end
+redis.call('HSET', KEYS[3], ARGV[2], ARGV[1])
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+for i = 1, pools do
+	redis.call('ZADD', KEYS[6 + i], ARGV[3], ARGV[1])
+	redis.call('SADD', KEYS[5], ARGV[7 + i])
+end
+for i = 8 + pools, #ARGV, 2 do
+	redis.call('HSET', KEYS[2], ARGV[i], ARGV[i + 1])
+end
+if ARGV[4] ~= '0' then
+	redis.call('ZADD', KEYS[4], ARGV[4], ARGV[2])
+end
+return 1
+`)
+
+// updateScript sets attributes on the ticket of the user and returns all of
+// its attributes, nothing is written when the user has no ticket. The caller
+// looks up the ticket, -1 is returned when the user's ticket changed meanwhile.
+//
+// KEYS: user index, ticket
+// ARGV: user id, ticket id, field, value, field, value...
+var updateScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return -1
+end
+if redis.call('EXISTS', KEYS[2]) == 0 then
+	return {}
+end
+for i = 3, #ARGV, 2 do
+	redis.call('HSET', KEYS[2], ARGV[i], ARGV[i + 1])
+end
+return redis.call('HGETALL', KEYS[2])
+`)
+
+// Times a script is run again when the ticket of the user changed between
+// looking it up and running the script
+const scriptAttempts = 3
+
+// addTicket runs the script adding the ticket, true once the ticket was added
+func (s *TicketServiceImpl) addTicket(queue string, ticket *model.Ticket, replace bool) (bool, error) {
+	fields, err := ticketFields(&ticket.Member)
+	if err != nil {
+		return false, err
+	}
+
+	expiry := 0.0
+	if s.MMRConfig.TicketTTL > 0 && !ticket.Member.NoExpiry {
+		expiry = s.heartbeatExpiry()
+	}
+	poolKeys := GetTicketPoolKeys(queue, &ticket.Member)
+
+	for attempt := 0; attempt < scriptAttempts; attempt++ {
+		previous, err := s.Redis.HGet(constants.GetTicketIndexName(queue), ticket.Member.Id).Result()
+		if err != nil && err != redis.Nil {
+			return false, err
+		}
+		queuePools, err := s.Redis.SMembers(constants.GetPoolSetName(queue)).Result()
+		if err != nil {
+			return false, err
+		}
+
+		keys := []string{
+			constants.GetIndexNameStr(queue),
+			constants.GetTicketKey(ticket.Member.TicketId),
+			constants.GetTicketIndexName(queue),
+			constants.GetHeartbeatSetName(queue),
+			constants.GetPoolSetName(queue),
+			constants.GetTicketKey(previous),
+		}
+		for _, poolKey := range poolKeys {
+			keys = append(keys, constants.GetPoolIndexName(queue, poolKey))
+		}
+		for _, poolKey := range queuePools {
+			keys = append(keys, constants.GetPoolIndexName(queue, poolKey))
+		}
+
+		args := []interface{}{ticket.Member.TicketId, ticket.Member.Id, ticket.Score, expiry, 0, previous, len(poolKeys)}
+		if replace {
+			args[4] = 1
+		}
+		for _, poolKey := range poolKeys {
+			args = append(args, poolKey)
+		}
+		for name, value := range fields {
+			args = append(args, name, value)
+		}
+
+		added, err := addScript.Run(s.Redis, keys, args...).Int64()
+		if err != nil {
+			return false, err
+		}
+		if added >= 0 {
+			return added == 1, nil
+		}
+	}
+	return false, fmt.Errorf("ticket of user %s kept changing", ticket.Member.Id)
+}
+
+// migrateLegacyTicket gives an id to a ticket stored by older versions, whose
+// sorted set member is the whole member data. It reports whether the member
+// is a legacy ticket and returns the migrated ticket, nil when another
+// replica migrated it first or the user joined again meanwhile.
+func (s *TicketServiceImpl) migrateLegacyTicket(queue string, key string, member redis.Z) (*model.Ticket, bool) {
+	raw, _ := member.Member.(string)
+	if !strings.HasPrefix(raw, "{") {
+		return nil, false
+	}
+	var memberData model.MemberData
+	if err := json.Unmarshal([]byte(raw), &memberData); err != nil || memberData.Id == "" {
+		return nil, false
+	}
+
+	// Whoever takes the legacy member out of the queue migrates it
+	removed, err := s.Redis.ZRem(constants.GetIndexNameStr(queue), raw).Result()
+	if err != nil {
+		slog.Error("Error migrating legacy ticket", "queue", queue, "userId", memberData.Id, "error", err)
+		return nil, true
+	}
+
+	pipe := s.Redis.TxPipeline()
+	pipe.ZRem(key, raw)
+	for _, poolKey := range GetTicketPoolKeys(queue, &memberData) {
+		pipe.ZRem(constants.GetPoolIndexName(queue, poolKey), raw)
+	}
+	if _, err := pipe.Exec(); err != nil {
+		slog.Error("Error migrating legacy ticket", "queue", queue, "userId", memberData.Id, "error", err)
+		return nil, true
+	}
+	if removed == 0 {
+		return nil, true
+	}
+
+	memberData.TicketId = uuid.Must(uuid.NewV7()).String()
+	ticket := &model.Ticket{Member: memberData, Score: member.Score}
+	added, err := s.addTicket(queue, ticket, false)
+	if err != nil {
+		slog.Error("Error migrating legacy ticket", "queue", queue, "userId", memberData.Id, "error", err)
+		return nil, true
+	}
+	if !added {
+		return nil, true
+	}
+	slog.Info("Migrated legacy ticket", "queue", queue, "userId", memberData.Id, "ticketId", memberData.TicketId)
+	return ticket, true
+}
+
+func (s *TicketServiceImpl) removeTicket(pipe redis.Pipeliner, queue string, member *model.MemberData) *redis.Cmd {
+	keys := []string{
+		constants.GetIndexNameStr(queue),
+		constants.GetTicketKey(member.TicketId),
+		constants.GetTicketIndexName(queue),
+		constants.GetHeartbeatSetName(queue),
+	}
+	for _, poolKey := range GetTicketPoolKeys(queue, member) {
+		keys = append(keys, constants.GetPoolIndexName(queue, poolKey))
+	}
+	return removeScript.Eval(pipe, keys, member.TicketId, member.Id)
+}
+
+// claimTickets runs the script claiming the tickets, true once all of them
+// were removed
+func (s *TicketServiceImpl) claimTickets(queue string, members []model.MemberData) (bool, error) {
+	keys := []string{
+		constants.GetIndexNameStr(queue),
+		constants.GetTicketIndexName(queue),
+		constants.GetHeartbeatSetName(queue),
+	}
+	args := make([]interface{}, 0, 3*len(members))
+	for i := range members {
+		poolKeys := GetTicketPoolKeys(queue, &members[i])
+		keys = append(keys, constants.GetTicketKey(members[i].TicketId))
+		for _, poolKey := range poolKeys {
+			keys = append(keys, constants.GetPoolIndexName(queue, poolKey))
+		}
+		args = append(args, members[i].TicketId, members[i].Id, len(poolKeys))
+	}
+
+	claimed, err := claimScript.Run(s.Redis, keys, args...).Int64()
+	if err != nil {
+		return false, fmt.Errorf("error claiming tickets - %s", err)
+	}
+	return claimed == 1, nil
+}
+
+// updateTicket writes the named fields of attributes onto the ticket of the user
+func (s *TicketServiceImpl) updateTicket(queue string, userId string, attributes *model.MemberData, names ...string) (*model.MemberData, error) {
+	fields, err := ticketFields(attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < scriptAttempts; attempt++ {
+		ticketId, err := s.Redis.HGet(constants.GetTicketIndexName(queue), userId).Result()
+		if err == redis.Nil {
+			return nil, fmt.Errorf("ticket of user %s not found", userId)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error updating ticket - %s", err)
+		}
+
+		args := []interface{}{userId, ticketId}
+		for _, name := range names {
+			value, ok := fields[name]
+			if !ok {
+				value = "null"
+			}
+			args = append(args, name, value)
+		}
+
+		keys := []string{constants.GetTicketIndexName(queue), constants.GetTicketKey(ticketId)}
+		result, err := updateScript.Run(s.Redis, keys, args...).Result()
+		if err != nil {
+			return nil, fmt.Errorf("error updating ticket - %s", err)
+		}
+		if changed, ok := result.(int64); ok && changed == -1 {
+			continue
+		}
+
+		values, _ := result.([]interface{})
+		if len(values) == 0 {
+			return nil, fmt.Errorf("ticket of user %s not found", userId)
+		}
+		return parseUpdatedTicket(values)
+	}
+	return nil, fmt.Errorf("ticket of user %s kept changing", userId)
+}
+
+func parseUpdatedTicket(values []interface{}) (*model.MemberData, error) {
+	stored := make(map[string]string, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		field, _ := values[i].(string)
+		value, _ := values[i+1].(string)
+		stored[field] = value
+	}
+
+	return parseTicketFields(stored)
+}
+
+// ticketFields splits the ticket into hash fields named like its JSON fields
+func ticketFields(memberData *model.MemberData) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(memberData)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't marshal member data %s", err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &raw); err != nil {
+		return nil, fmt.Errorf("couldn't split member data %s", err)
+	}
+
+	fields := make(map[string]interface{}, len(raw))
+	for name, value := range raw {
+		fields[name] = string(value)
+	}
+	return fields, nil
+}
+
+func parseTicketFields(fields map[string]string) (*model.MemberData, error) {
+	raw := make(map[string]json.RawMessage, len(fields))
+	for name, value := range fields {
+		raw[name] = json.RawMessage(value)
+	}
+
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var memberData model.MemberData
+	if err := json.Unmarshal(encoded, &memberData); err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal member data %s", err)
+	}
+	return &memberData, nil
+}
@@ -0,0 +1,317 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"mmf/config"
+	"mmf/internal/clock"
+	"mmf/internal/constants"
+	"mmf/internal/metrics"
+	"mmf/internal/model"
+	ws "mmf/internal/server/websockets"
+	"mmf/internal/services"
+	"mmf/internal/wires"
+)
+
+// Matches older than this don't count towards rates and wait times
+const statsWindow = 10 * time.Minute
+
+// Wait samples needed near a rating before the estimate is narrowed to it
+const minRatingSamples = 3
+
+type RatingDistribution struct {
+	Min    float64 `json:"min"`
+	P25    float64 `json:"p25"`
+	Median float64 `json:"median"`
+	P75    float64 `json:"p75"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+}
+
+type PoolStats struct {
+	Key               string             `json:"key"`
+	PlayersWaiting    int                `json:"playersWaiting"`
+	Rating            RatingDistribution `json:"rating"`
+	MatchesPerMinute  float64            `json:"matchesPerMinute"`
+	MedianWaitSeconds int64              `json:"medianWaitSeconds"`
+}
+
+type QueueStats struct {
+	Queue             string             `json:"queue"`
+	PlayersWaiting    int                `json:"playersWaiting"`
+	Rating            RatingDistribution `json:"rating"`
+	MatchesPerMinute  float64            `json:"matchesPerMinute"`
+	MedianWaitSeconds int64              `json:"medianWaitSeconds"`
+	Pools             []PoolStats        `json:"pools,omitempty"` // Lichess queues only
+	UpdatedAt         int64              `json:"updatedAt"`
+}
+
+// A matched player, kept for the stats window
+type waitSample struct {
+	matchedAt int64
+	poolKey   string
+	score     float64
+	wait      int64
+	// One sample per match carries it so matches can be counted
+	firstOfMatch bool
+}
+
+type queueState struct {
+	// When this instance started recording the queue, rates are over the time since
+	observedSince  int64
+	samples        []waitSample
+	snapshot       *QueueStats
+	lastStatusPush int64
+}
+
+var queues = make(map[string]*queueState)
+var queuesMutex sync.Mutex
+
+func getQueueState(queue string, now int64) *queueState {
+	state, ok := queues[queue]
+	if !ok {
+		state = &queueState{observedSince: now}
+		queues[queue] = state
+	}
+	return state
+}
+
+// RecordMatch adds the wait times of matched players, poolKey is set for lichess queues
+func RecordMatch(queue string, poolKey string, tickets []model.Ticket, now int64) {
+	queuesMutex.Lock()
+	defer queuesMutex.Unlock()
+
+	metrics.MatchesCreated.WithLabelValues(queue).Inc()
+
+	state := getQueueState(queue, now)
+	for i, ticket := range tickets {
+		wait := int64(0)
+		if ticket.Member.JoinedAt != 0 {
+			wait = now - ticket.Member.JoinedAt
+			metrics.TimeToMatch.WithLabelValues(queue).Observe(float64(wait))
+		}
+		state.samples = append(state.samples, waitSample{
+			matchedAt:    now,
+			poolKey:      poolKey,
+			score:        ticket.Score,
+			wait:         wait,
+			firstOfMatch: i == 0,
+		})
+	}
+}
+
+// Update recomputes the stats of the queue from the tickets left after the
+// crawler tick and pushes QUEUE_STATUS to waiting players when it's due. When
+// the tickets can't be fetched the previous stats are kept.
+func Update(mmrConfig config.MMRConfig, queue string, now int64) (*QueueStats, error) {
+	allTickets := wires.Instance.TicketService.GetAllTickets(queue)
+	if allTickets == nil {
+		return previousSnapshot(queue), fmt.Errorf("couldn't fetch tickets of queue %s", queue)
+	}
+	tickets := *allTickets
+
+	pools := make(map[string][]model.Ticket)
+	if constants.IsLichessQueue(queue) {
+		for _, poolKey := range wires.Instance.TicketService.GetPoolKeys(queue) {
+			poolTickets := wires.Instance.TicketService.GetPoolTickets(queue, poolKey)
+			if poolTickets == nil {
+				return previousSnapshot(queue), fmt.Errorf("couldn't fetch tickets of pool %s in queue %s", poolKey, queue)
+			}
+			pools[poolKey] = *poolTickets
+		}
+	}
+
+	metrics.QueueDepth.WithLabelValues(queue).Set(float64(len(tickets)))
+
+	queuesMutex.Lock()
+	state := getQueueState(queue, now)
+	state.samples = pruneSamples(state.samples, now)
+	snapshot := computeStats(queue, tickets, pools, state.samples, observedWindow(state.observedSince, now), now)
+	state.snapshot = snapshot
+
+	pushDue := mmrConfig.QueueStatusInterval > 0 && now-state.lastStatusPush >= int64(mmrConfig.QueueStatusInterval)
+	var estimates map[string]int64
+	if pushDue {
+		state.lastStatusPush = now
+		estimates = make(map[string]int64, len(tickets))
+		for _, ticket := range tickets {
+			estimates[ticket.Member.Id] = estimateWait(state.samples, ticket.Score, services.GetTicketPoolKeys(queue, &ticket.Member), mmrConfig.Range)
+		}
+	}
+	queuesMutex.Unlock()
+
+	// Sending doesn't block, the connections have their own writers
+	for _, ticket := range tickets {
+		estimate, ok := estimates[ticket.Member.Id]
+		if !ok {
+			continue
+		}
+		waiting := int64(0)
+		if ticket.Member.JoinedAt != 0 {
+			waiting = now - ticket.Member.JoinedAt
+		}
+		ws.SendJSONToUser(ticket.Member.Id, ws.QueueStatus, ws.QueueStatusResponse{
+			Queue:          queue,
+			PlayersWaiting: snapshot.PlayersWaiting,
+			WaitingTime:    waiting,
+			EstimatedWait:  estimate,
+		})
+	}
+
+	return snapshot, nil
+}
+
+// GetQueueStats returns the stats of the last crawler tick, computing them if
+// the queue wasn't evaluated yet
+func GetQueueStats(mmrConfig config.MMRConfig, queue string) (*QueueStats, error) {
+	if snapshot := previousSnapshot(queue); snapshot != nil {
+		return snapshot, nil
+	}
+
+	// No status push from here, that is done on crawler ticks
+	mmrConfig.QueueStatusInterval = 0
+	return Update(mmrConfig, queue, clock.Now().Unix())
+}
+
+func previousSnapshot(queue string) *QueueStats {
+	queuesMutex.Lock()
+	defer queuesMutex.Unlock()
+	return getQueueState(queue, clock.Now().Unix()).snapshot
+}
+
+func computeStats(queue string, tickets []model.Ticket, pools map[string][]model.Ticket, samples []waitSample, window time.Duration, now int64) *QueueStats {
+	stats := &QueueStats{
+		Queue:             queue,
+		PlayersWaiting:    len(tickets),
+		Rating:            ratingDistribution(tickets),
+		MatchesPerMinute:  matchesPerMinute(samples, nil, window),
+		MedianWaitSeconds: medianWait(samples, nil),
+		UpdatedAt:         now,
+	}
+
+	for poolKey, poolTickets := range pools {
+		inPool := func(sample waitSample) bool { return sample.poolKey == poolKey }
+		stats.Pools = append(stats.Pools, PoolStats{
+			Key:               poolKey,
+			PlayersWaiting:    len(poolTickets),
+			Rating:            ratingDistribution(poolTickets),
+			MatchesPerMinute:  matchesPerMinute(samples, inPool, window),
+			MedianWaitSeconds: medianWait(samples, inPool),
+		})
+	}
+	slices.SortFunc(stats.Pools, func(a, b PoolStats) int {
+		return b.PlayersWaiting - a.PlayersWaiting
+	})
+
+	return stats
+}
+
+// Median wait of recent matches near the rating, falling back to the whole
+// pool or queue when there are too few of them. The best pool wins.
+func estimateWait(samples []waitSample, rating float64, poolKeys []string, ratingRange int) int64 {
+	if len(poolKeys) == 0 {
+		return estimatePoolWait(samples, rating, nil, ratingRange)
+	}
+
+	estimate := int64(-1)
+	for _, poolKey := range poolKeys {
+		poolKey := poolKey
+		poolEstimate := estimatePoolWait(samples, rating, func(sample waitSample) bool { return sample.poolKey == poolKey }, ratingRange)
+		if poolEstimate >= 0 && (estimate < 0 || poolEstimate < estimate) {
+			estimate = poolEstimate
+		}
+	}
+	return estimate
+}
+
+func estimatePoolWait(samples []waitSample, rating float64, filter func(waitSample) bool, ratingRange int) int64 {
+	nearRating := func(sample waitSample) bool {
+		return (filter == nil || filter(sample)) && math.Abs(sample.score-rating) <= float64(ratingRange)
+	}
+	if countSamples(samples, nearRating) >= minRatingSamples {
+		return medianWait(samples, nearRating)
+	}
+	return medianWait(samples, filter)
+}
+
+func pruneSamples(samples []waitSample, now int64) []waitSample {
+	cutoff := now - int64(statsWindow.Seconds())
+	i := 0
+	for i < len(samples) && samples[i].matchedAt < cutoff {
+		i++
+	}
+	return samples[i:]
+}
+
+func countSamples(samples []waitSample, filter func(waitSample) bool) int {
+	count := 0
+	for _, sample := range samples {
+		if filter == nil || filter(sample) {
+			count++
+		}
+	}
+	return count
+}
+
+// Time the samples cover, from the start of the recording up to the stats
+// window. At least a minute so the first matches don't read as a burst.
+func observedWindow(observedSince int64, now int64) time.Duration {
+	window := time.Duration(now-observedSince) * time.Second
+	return min(max(window, time.Minute), statsWindow)
+}
+
+func matchesPerMinute(samples []waitSample, filter func(waitSample) bool, window time.Duration) float64 {
+	matches := 0
+	for _, sample := range samples {
+		if sample.firstOfMatch && (filter == nil || filter(sample)) {
+			matches++
+		}
+	}
+	return float64(matches) / window.Minutes()
+}
+
+// -1 when there are no samples
+func medianWait(samples []waitSample, filter func(waitSample) bool) int64 {
+	var waits []int64
+	for _, sample := range samples {
+		if filter == nil || filter(sample) {
+			waits = append(waits, sample.wait)
+		}
+	}
+	if len(waits) == 0 {
+		return -1
+	}
+
+	slices.Sort(waits)
+	return waits[len(waits)/2]
+}
+
+func ratingDistribution(tickets []model.Ticket) RatingDistribution {
+	if len(tickets) == 0 {
+		return RatingDistribution{}
+	}
+
+	scores := make([]float64, 0, len(tickets))
+	sum := 0.0
+	for _, ticket := range tickets {
+		scores = append(scores, ticket.Score)
+		sum += ticket.Score
+	}
+	slices.Sort(scores)
+
+	percentile := func(p float64) float64 {
+		return scores[int(p*float64(len(scores)-1))]
+	}
+	return RatingDistribution{
+		Min:    scores[0],
+		P25:    percentile(0.25),
+		Median: percentile(0.5),
+		P75:    percentile(0.75),
+		Max:    scores[len(scores)-1],
+		Mean:   sum / float64(len(scores)),
+	}
+}
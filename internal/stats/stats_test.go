@@ -0,0 +1,93 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"mmf/internal/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const now = int64(1700000000)
+
+func tickets(scores ...float64) []model.Ticket {
+	result := make([]model.Ticket, 0, len(scores))
+	for _, score := range scores {
+		result = append(result, model.Ticket{Score: score})
+	}
+	return result
+}
+
+func sample(poolKey string, score float64, wait int64) waitSample {
+	return waitSample{matchedAt: now, poolKey: poolKey, score: score, wait: wait, firstOfMatch: true}
+}
+
+func TestRatingDistribution(t *testing.T) {
+	assert.Equal(t, RatingDistribution{}, ratingDistribution(nil))
+
+	distribution := ratingDistribution(tickets(1500, 1000, 1400, 1200, 1300))
+	assert.Equal(t, RatingDistribution{Min: 1000, P25: 1200, Median: 1300, P75: 1400, Max: 1500, Mean: 1280}, distribution)
+}
+
+func TestMedianWait(t *testing.T) {
+	assert.Equal(t, int64(-1), medianWait(nil, nil))
+
+	samples := []waitSample{sample("a", 1000, 30), sample("b", 1000, 10), sample("a", 1000, 20)}
+	assert.Equal(t, int64(20), medianWait(samples, nil))
+	assert.Equal(t, int64(30), medianWait(samples, func(s waitSample) bool { return s.poolKey == "a" }))
+	assert.Equal(t, int64(-1), medianWait(samples, func(s waitSample) bool { return s.poolKey == "c" }))
+}
+
+func TestMatchesPerMinuteCountsMatchesNotPlayers(t *testing.T) {
+	samples := []waitSample{}
+	for i := 0; i < 5; i++ {
+		first := sample("", 1000, 10)
+		second := sample("", 1000, 10)
+		second.firstOfMatch = false
+		samples = append(samples, first, second)
+	}
+
+	assert.InDelta(t, 5/statsWindow.Minutes(), matchesPerMinute(samples, nil, statsWindow), 1e-9)
+}
+
+func TestObservedWindowIsTheTimeSinceTheRecordingStarted(t *testing.T) {
+	assert.Equal(t, 4*time.Minute, observedWindow(now-240, now))
+	assert.Equal(t, time.Minute, observedWindow(now-5, now))
+	assert.Equal(t, statsWindow, observedWindow(now-int64(statsWindow.Seconds())*3, now))
+
+	// 6 matches in the first 3 minutes of an instance are 2 per minute
+	samples := []waitSample{}
+	for i := 0; i < 6; i++ {
+		samples = append(samples, sample("", 1000, 10))
+	}
+	assert.InDelta(t, 2, matchesPerMinute(samples, nil, observedWindow(now-180, now)), 1e-9)
+}
+
+func TestPruneSamplesDropsSamplesOutsideTheWindow(t *testing.T) {
+	old := sample("", 1000, 10)
+	old.matchedAt = now - int64(statsWindow.Seconds()) - 1
+	recent := sample("", 1000, 20)
+
+	assert.Equal(t, []waitSample{recent}, pruneSamples([]waitSample{old, recent}, now))
+}
+
+func TestEstimateWaitPrefersSamplesNearTheRating(t *testing.T) {
+	samples := []waitSample{
+		sample("", 1000, 10), sample("", 1010, 12), sample("", 990, 14),
+		sample("", 2000, 300), sample("", 2010, 320),
+	}
+
+	assert.Equal(t, int64(12), estimateWait(samples, 1000, nil, 50))
+	// Too few samples near the rating, the whole queue is used
+	assert.Equal(t, int64(14), estimateWait(samples, 2000, nil, 50))
+	assert.Equal(t, int64(-1), estimateWait(nil, 1000, nil, 50))
+}
+
+func TestEstimateWaitTakesTheBestPool(t *testing.T) {
+	samples := []waitSample{sample("blitz", 1500, 40), sample("rapid", 1500, 90)}
+
+	assert.Equal(t, int64(40), estimateWait(samples, 1500, []string{"rapid", "blitz"}, 50))
+	assert.Equal(t, int64(90), estimateWait(samples, 1500, []string{"rapid", "bullet"}, 50))
+	assert.Equal(t, int64(-1), estimateWait(samples, 1500, []string{"bullet"}, 50))
+}
@@ -0,0 +1,127 @@
+package tracing
+
+import (
+	"context"
+	"log/slog"
+
+	"mmf/config"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("mmf")
+
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Init installs the global tracer provider exporting to the OTLP collector,
+// the returned function flushes pending spans on shutdown. Without an
+// endpoint spans are not recorded.
+func Init(tracingConfig config.TracingConfig) func(context.Context) error {
+	otel.SetTextMapPropagator(propagator)
+
+	if tracingConfig.Endpoint == "" {
+		return func(context.Context) error { return nil }
+	}
+
+	exporter, err := otlptracegrpc.New(context.Background(), otlptracegrpc.WithEndpointURL(tracingConfig.Endpoint))
+	if err != nil {
+		slog.Error("Error creating trace exporter, tracing is disabled", "error", err)
+		return func(context.Context) error { return nil }
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(tracingConfig.ServiceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(tracingConfig.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	slog.Info("Exporting traces", "endpoint", tracingConfig.Endpoint, "sampleRatio", tracingConfig.SampleRatio)
+
+	return provider.Shutdown
+}
+
+// Start starts a span, a child of the span in ctx if there is one
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartLinked starts a root span for work that outlives its caller, linked to
+// the span in ctx and to the spans of the given trace parents
+func StartLinked(ctx context.Context, name string, traceParents []string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	links := []trace.Link{}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		links = append(links, trace.Link{SpanContext: spanContext})
+	}
+	for _, traceParent := range traceParents {
+		if link, ok := linkTo(traceParent); ok {
+			links = append(links, link)
+		}
+	}
+	return tracer.Start(ctx, name, trace.WithNewRoot(), trace.WithAttributes(attrs...), trace.WithLinks(links...))
+}
+
+// Fail marks the span as failed
+func Fail(span trace.Span, reason string) {
+	span.SetStatus(codes.Error, reason)
+}
+
+// TraceParent returns the W3C traceparent of the span in ctx, so it can be
+// stored with data that outlives the span
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+func linkTo(traceParent string) (trace.Link, bool) {
+	if traceParent == "" {
+		return trace.Link{}, false
+	}
+	carrier := propagation.MapCarrier{"traceparent": traceParent}
+	spanContext := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), carrier))
+	if !spanContext.IsValid() {
+		return trace.Link{}, false
+	}
+	return trace.Link{SpanContext: spanContext}, true
+}
+
+// Stages is a span split into consecutive child spans, one per stage
+type Stages struct {
+	ctx     context.Context
+	current trace.Span
+}
+
+func NewStages(ctx context.Context) *Stages {
+	return &Stages{ctx: ctx}
+}
+
+// Next ends the running stage and starts the next one, returning its context
+func (s *Stages) Next(name string, attrs ...attribute.KeyValue) context.Context {
+	s.End()
+	ctx, span := Start(s.ctx, name, attrs...)
+	s.current = span
+	return ctx
+}
+
+// Fail marks the running stage as failed
+func (s *Stages) Fail(reason string) {
+	if s.current != nil {
+		Fail(s.current, reason)
+	}
+}
+
+// End ends the running stage
+func (s *Stages) End() {
+	if s.current != nil {
+		s.current.End()
+		s.current = nil
+	}
+}
@@ -2,12 +2,19 @@ package client
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"mmf/config"
+	"mmf/internal/clock"
+	"mmf/internal/constants"
+	"mmf/internal/logging"
+	"mmf/internal/metrics"
 	"mmf/internal/model"
 	ws "mmf/internal/server/websockets"
 
@@ -16,18 +23,19 @@ import (
 	"time"
 )
 
-func ScheduleMatch(url string, requestBody interface{}) (*io.ReadCloser, error) {
+// ScheduleMatch posts the match to the game service, integration labels its metrics
+func ScheduleMatch(ctx context.Context, integration string, url string, requestBody interface{}) (*io.ReadCloser, error) {
 	requestBodyBytes, err := json.Marshal(requestBody)
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBodyBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBodyBytes))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
+	client := metrics.HTTPClient(integration)
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -41,29 +49,43 @@ func ScheduleMatch(url string, requestBody interface{}) (*io.ReadCloser, error)
 	return &resp.Body, nil
 }
 
-// Finds the time, increment & collateral same for tickets
-func FindTimeIncrAndColl(ticket1, ticket2 model.Ticket) (int, int, model.Collateral) {
-	for _, data1 := range ticket1.Member.LichessCustomData {
-		for _, data2 := range ticket2.Member.LichessCustomData {
-			if data1.Time == data2.Time && data1.Increment == data2.Increment && data1.Collateral == data2.Collateral {
-				return data1.Time, data1.Increment, data1.Collateral
+// Finds the preferences of both tickets that share a pool and have compatible colors
+func FindMatchingCustomData(ticket1, ticket2 model.Ticket) (*model.LichessCustomData, *model.LichessCustomData) {
+	for i, data1 := range ticket1.Member.LichessCustomData {
+		for j, data2 := range ticket2.Member.LichessCustomData {
+			if data1.Key() == data2.Key() && model.ColorsCompatible(data1.Color, data2.Color) {
+				return &ticket1.Member.LichessCustomData[i], &ticket2.Member.LichessCustomData[j]
 			}
 		}
 	}
 
-	return 0, 0, ""
+	return nil, nil
+}
+
+// Finds the time, increment & collateral same for tickets
+func FindTimeIncrAndColl(ticket1, ticket2 model.Ticket) (int, int, model.Collateral) {
+	data1, _ := FindMatchingCustomData(ticket1, ticket2)
+	if data1 == nil {
+		return 0, 0, ""
+	}
+
+	return data1.Time, data1.Increment, data1.Collateral
 }
 
-func ScheduleDota2Match(tickets1 []model.Ticket, tickets2 []model.Ticket) {
-	log.Println("Scheduling Dota 2 match")
+func ScheduleDota2Match(ctx context.Context, tickets1 []model.Ticket, tickets2 []model.Ticket, matchId string, queue string, region constants.Region) error {
+	logging.Match(matchId, queue).Info("Scheduling Dota 2 match", "region", region.String())
+
+	lobbyTemplate, ok := config.GlobalConfig.Dota2Lobbies[queue]
+	if !ok {
+		return fmt.Errorf("no lobby template for queue %s", queue)
+	}
 
 	// map tickets1 to TeamA
 	teamA := []int64{}
 	for _, ticket := range tickets1 {
 		player, err := strconv.ParseInt(ticket.Member.Id, 10, 64)
 		if err != nil {
-			log.Println("Error parsing ticket member:", err)
-			return
+			return fmt.Errorf("error parsing ticket member: %s", err)
 		}
 		teamA = append(teamA, player)
 	}
@@ -73,34 +95,62 @@ func ScheduleDota2Match(tickets1 []model.Ticket, tickets2 []model.Ticket) {
 	for _, ticket := range tickets2 {
 		player, err := strconv.ParseInt(ticket.Member.Id, 10, 64)
 		if err != nil {
-			log.Println("Error parsing ticket member:", err)
-			return
+			return fmt.Errorf("error parsing ticket member: %s", err)
 		}
 		teamB = append(teamB, player)
 	}
 
+	// Region chosen by the matchmaker takes precedence over the queue default
+	serverRegion := lobbyTemplate.ServerRegion
+	if regionId, ok := constants.GetDota2ServerRegion(region); ok {
+		serverRegion = regionId
+	}
+
+	passKey, err := generatePassKey()
+	if err != nil {
+		return fmt.Errorf("error generating lobby pass key: %s", err)
+	}
+
 	url := config.GlobalConfig.D2Api.URL + "/v1/match"
 	requestBody := MatchRequestBodyD2{
 		TeamA: teamA,
 		TeamB: teamB,
 		LobbyConfig: LobbyConfig{
-			GameName:     "Relative Game Test",
-			ServerRegion: 3,
-			PassKey:      "test",
-			GameMode:     "AP",
+			GameName:     fmt.Sprintf("%s %s", lobbyTemplate.GameName, matchId),
+			ServerRegion: serverRegion,
+			PassKey:      passKey,
+			GameMode:     lobbyTemplate.GameMode,
 		},
 		StartTime: "", // If sent as empty string, the match will be scheduled immediately
 	}
 
-	if _, err := ScheduleMatch(url, requestBody); err != nil {
-		log.Println("Error making REST call:", err)
-		return
+	resp, err := ScheduleMatch(ctx, "dota2", url, requestBody)
+	if err != nil {
+		return err
 	}
+	(*resp).Close()
+
+	lobbyInfo := LobbyInfoResponse{MatchId: matchId, LobbyConfig: requestBody.LobbyConfig}
+	for _, ticket := range append(tickets1, tickets2...) {
+		ws.SendJSONToUser(ticket.Member.Id, ws.LobbyInfo, lobbyInfo)
+	}
+
+	return nil
 }
 
-func ScheduleCS2Match(tickets1 []model.Ticket, tickets2 []model.Ticket) {
-	log.Println("Scheduling CS2 match")
+// Random pass key so only invited players can join the lobby
+func generatePassKey() (string, error) {
+	passKey := make([]byte, 8)
+	if _, err := rand.Read(passKey); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(passKey), nil
+}
 
+func ScheduleCS2Match(ctx context.Context, tickets1 []model.Ticket, tickets2 []model.Ticket, matchId string, mapName string, region constants.Region) error {
+	slog.Info("Scheduling CS2 match", "matchId", matchId, "map", mapName, "region", region.String())
+
+	cs2Cfg := config.GlobalConfig.CS2Match
 	url := config.GlobalConfig.CS2Api.URL + "/v1/start-match"
 	requestBody := MatchRequestBodyCS2{
 		Team1: Team{
@@ -109,16 +159,18 @@ func ScheduleCS2Match(tickets1 []model.Ticket, tickets2 []model.Ticket) {
 		Team2: Team{
 			Name: "team2",
 		},
-		Players: []PlayerDatHost{},
+		Players:  []PlayerDatHost{},
+		Location: constants.GetCS2Location(region),
 		Settings: GameSettings{
-			Map:                 "de_dust2",
-			ConnectTime:         120,
-			MatchBeginCountdown: 10,
+			Map:                 mapName,
+			ConnectTime:         cs2Cfg.ConnectTime,
+			MatchBeginCountdown: cs2Cfg.MatchBeginCountdown,
 			EnableTechPause:     false,
 		},
 		Webhooks: Webhooks{
-			MatchEndURL: "",
-			RoundEndURL: "",
+			MatchEndURL:         fmt.Sprint(config.GlobalConfig.MMFApi.URL, "/results/", matchId),
+			RoundEndURL:         "",
+			AuthorizationHeader: "Bearer " + config.GlobalConfig.MMFApi.ApiKey,
 		},
 	}
 
@@ -136,27 +188,27 @@ func ScheduleCS2Match(tickets1 []model.Ticket, tickets2 []model.Ticket) {
 		})
 	}
 
-	resp, err := ScheduleMatch(url, requestBody)
+	resp, err := ScheduleMatch(ctx, "cs2", url, requestBody)
 	if err != nil {
-		log.Println("Error making REST call:", err)
-		return
+		return err
 	}
 	defer (*resp).Close()
 
 	var matchResponse MatchResponseBodyCS2
 	if err := json.NewDecoder(*resp).Decode(&matchResponse); err != nil {
-		log.Println("Error decoding response:", err)
-		return
+		return fmt.Errorf("error decoding response: %s", err)
 	}
 
 	for _, ticket := range append(tickets1, tickets2...) {
 		ws.SendJSONToUser(ticket.Member.Id, ws.Info, matchResponse)
 	}
+
+	return nil
 }
 
-func ScheduleLichessMatch(tickets1 []model.Ticket, tickets2 []model.Ticket, matchId string) (*CreateLichessMatchRequest, error) {
+func ScheduleLichessMatch(ctx context.Context, tickets1 []model.Ticket, tickets2 []model.Ticket, matchId string) (*CreateLichessMatchRequest, error) {
 	if len(tickets1) == 0 || len(tickets2) == 0 {
-		log.Println("Insufficient players to schedule a match")
+		slog.Warn("Insufficient players to schedule a match", "matchId", matchId)
 		return nil, errors.New("insufficient players to schedule a match")
 	}
 
@@ -192,24 +244,33 @@ func ScheduleLichessMatch(tickets1 []model.Ticket, tickets2 []model.Ticket, matc
 	player2 := tickets2[0].Member.Id // steamId for player2
 
 	url := config.GlobalConfig.LichessApi.URL + "/v1/match"
-	limit, incr, _ := FindTimeIncrAndColl(tickets1[0], tickets2[0])
-	if limit == 0 && incr == 0 {
-		log.Println("Error finding time and increment for players")
+	data1, data2 := FindMatchingCustomData(tickets1[0], tickets2[0])
+	if data1 == nil || (data1.Time == 0 && data1.Increment == 0) {
+		slog.Warn("Error finding time and increment for players", "matchId", matchId)
 		return nil, errors.New("error finding time and increment for players")
 	}
 
+	// player1 gets the white pieces, swap if either preference asks otherwise
+	if data1.Color == model.Black || data2.Color == model.White {
+		player1, player2 = player2, player1
+	}
+
+	variant := data1.Variant
+	if variant == "" {
+		variant = model.Standard
+	}
+
 	requestBody := CreateLichessMatchRequest{
 		Player1: player1,
 		Player2: player2,
-		Variant: Standard,
+		Variant: variant,
 		Clock: Clock{
-			Increment: incr,
-			Limit:     limit * 60,
+			Increment: data1.Increment,
+			Limit:     data1.Time * 60,
 		},
-		Rated:         false,
-		Rules:         []Rules{},
-		PairAt:        int(time.Now().Add(30 * time.Second).UnixMilli()),
-		StartClocksAt: int(time.Now().Add(1 * time.Minute).UnixMilli()),
+		Rated:         data1.Rated,
+		PairAt:        int(clock.Now().Add(30 * time.Second).UnixMilli()),
+		StartClocksAt: int(clock.Now().Add(1 * time.Minute).UnixMilli()),
 		Webhook:       fmt.Sprint(config.GlobalConfig.MatchEndWebhook.URL, "/", matchId),
 		Instant:       true,
 	}
@@ -218,18 +279,18 @@ func ScheduleLichessMatch(tickets1 []model.Ticket, tickets2 []model.Ticket, matc
 		Id string `json:"lichessId"`
 	}
 
-	body, err := ScheduleMatch(url, requestBody)
+	body, err := ScheduleMatch(ctx, "lichess", url, requestBody)
 	if err != nil {
 		ws.SendMessageToUser(tickets1[0].Member.Id, ws.Error, "Error scheduling match")
 		ws.SendMessageToUser(tickets2[0].Member.Id, ws.Error, "Error scheduling match")
 
-		log.Println("Error making REST call:", err)
+		slog.Error("Error scheduling lichess match", "matchId", matchId, "error", err)
 		return nil, err
 	}
 
 	var lichessId LichessId
 	if err := json.NewDecoder(*body).Decode(&lichessId); err != nil {
-		log.Println("Error decoding response:", err)
+		slog.Error("Error decoding lichess response", "matchId", matchId, "error", err)
 		return nil, err
 	}
 
@@ -238,50 +299,73 @@ func ScheduleLichessMatch(tickets1 []model.Ticket, tickets2 []model.Ticket, matc
 		ws.SendJSONToUser(ticket.Member.Id, ws.Info, lichessId)
 	}
 
-	log.Println("Lichess match scheduled successfully")
+	slog.Info("Lichess match scheduled", "matchId", matchId, "lichessId", lichessId.Id)
 
 	// Notify showdown-api of match status
-	go notifyShowdownAPI(matchId, lichessId.Id)
+	go notifyShowdownAPI(ctx, matchId, lichessId.Id)
 
 	return &requestBody, nil
 }
 
-func notifyShowdownAPI(matchId, lichessId string) {
+// ForwardMatchResult passes the result reported by a game server to the match end webhook
+func ForwardMatchResult(matchId string, result []byte) error {
+	url := fmt.Sprint(config.GlobalConfig.MatchEndWebhook.URL, "/", matchId)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(result))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := metrics.HTTPClient("match_webhook")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code: %d, with error: %s", resp.StatusCode, string(err))
+	}
+
+	return nil
+}
+
+func notifyShowdownAPI(ctx context.Context, matchId, lichessId string) {
 	showdownReq := &StartLichessShowdownMatchRequest{
 		MatchID:   matchId,
 		LichessID: lichessId,
 	}
 
 	url := fmt.Sprintf("%s/chess/start_chess_match", config.GlobalConfig.ShowdownApi.URL)
-	log.Println(url)
-	client := &http.Client{}
+	client := metrics.HTTPClient("showdown")
 
 	jsonData, err := json.Marshal(showdownReq)
 	if err != nil {
-		log.Println("Error marshalling showdown request:", err)
+		slog.Error("Error marshalling showdown request", "matchId", matchId, "error", err)
 		return
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		log.Println("Error creating showdown api request:", err)
+		slog.Error("Error creating showdown api request", "matchId", matchId, "error", err)
 		return
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Println("Error making showdown REST call:", err)
+		slog.Error("Error making showdown REST call", "matchId", matchId, "url", url, "error", err)
 		return
 	}
 
 	if resp.StatusCode != http.StatusOK {
 		err, _ := io.ReadAll(resp.Body)
-		log.Println("Failed to start match on Showdown Api - Unexpected status code:", resp.StatusCode, "with error:", string(err))
+		slog.Error("Failed to start match on Showdown Api", "matchId", matchId, "status", resp.StatusCode, "body", string(err))
 		return
 	}
 
-	log.Println("Showdown API notified")
+	slog.Info("Showdown API notified", "matchId", matchId)
 }
 
 type SubgraphRequestData struct {
@@ -301,12 +385,13 @@ type SubgraphQuickPlayMatchInfo struct {
 
 type QuickplayPositionInfo struct {
 	Id                string `json:"id"`
+	Size              string `json:"size"` // Staked amount in base units of the collateral token
 	Status            string `json:"status"`
 	UserWalletAddress string `json:"userWalletAddress"`
 }
 
-func getQPMatchInfoFromSubgraph(matchId string) (*SubgraphResponse, error) {
-	client := &http.Client{}
+func getQPMatchInfoFromSubgraph(ctx context.Context, matchId string) (*SubgraphResponse, error) {
+	client := metrics.HTTPClient("subgraph")
 	variables := map[string]string{"id": matchId}
 
 	subgraphRequestData := SubgraphRequestData{
@@ -328,7 +413,7 @@ func getQPMatchInfoFromSubgraph(matchId string) (*SubgraphResponse, error) {
 		return nil, fmt.Errorf("error marshalling HTTP request: %s", err)
 	}
 
-	req, err := http.NewRequest("POST", config.GlobalConfig.Subgraph.URL, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", config.GlobalConfig.Subgraph.URL, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("error creating HTTP request: %s", err)
 	}
@@ -357,15 +442,18 @@ func getQPMatchInfoFromSubgraph(matchId string) (*SubgraphResponse, error) {
 	return &subgraphResponse, nil
 }
 
-func GetQPUsersPaymentStatusFromSubgraph(matchId string) map[string]bool {
-	usersPaymentInfo := make(map[string]bool, 2)
-	qpMatchInfo, err := getQPMatchInfoFromSubgraph(matchId)
+// GetQPUsersPaymentStatusFromSubgraph returns the amount staked by every wallet that joined the match
+func GetQPUsersPaymentStatusFromSubgraph(ctx context.Context, matchId string) map[string]string {
+	usersPaymentInfo := make(map[string]string, 2)
+	qpMatchInfo, err := getQPMatchInfoFromSubgraph(ctx, matchId)
 	if err != nil {
-		log.Println("Error with fetching Quickplay info from subgraph:", err)
+		slog.Error("Error fetching Quickplay info from subgraph", "matchId", matchId, "error", err)
 		return usersPaymentInfo
 	}
 	for _, position := range qpMatchInfo.Data.ChessQuickplayMatch.Positions {
-		usersPaymentInfo[position.UserWalletAddress] = position.Status == "JOINED"
+		if position.Status == "JOINED" {
+			usersPaymentInfo[position.UserWalletAddress] = position.Size
+		}
 	}
 
 	return usersPaymentInfo
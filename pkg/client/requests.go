@@ -36,8 +36,9 @@ type GameSettings struct {
 	EnableTechPause     bool   `json:"enable_tech_pause"`
 }
 type Webhooks struct {
-	MatchEndURL string `json:"match_end_url"`
-	RoundEndURL string `json:"round_end_url"`
+	MatchEndURL         string `json:"match_end_url"`
+	RoundEndURL         string `json:"round_end_url"`
+	AuthorizationHeader string `json:"authorization_header,omitempty"` // Sent by the game server with each webhook call
 }
 type MatchRequestBodyCS2 struct {
 	Team1    Team            `json:"team1"`
@@ -45,6 +46,7 @@ type MatchRequestBodyCS2 struct {
 	Webhooks Webhooks        `json:"webhooks"`
 	Settings GameSettings    `json:"settings"`
 	Players  []PlayerDatHost `json:"players"`
+	Location string          `json:"location,omitempty"` // Server location, any when omitted
 }
 
 // Lichess
@@ -78,33 +80,24 @@ type Clock struct {
 	Limit     int `json:"limit"`
 }
 
-type Variant string
+// Variant and Color moved to the model with the game preferences, they are
+// kept here for existing importers
+type Variant = model.Variant
 
 const (
-	Standard      Variant = "standard"
-	Chess960      Variant = "chess960"
-	Crazyhouse    Variant = "crazyhouse"
-	Antichess     Variant = "antichess"
-	Atomic        Variant = "atomic"
-	Horde         Variant = "horde"
-	KingOfTheHill Variant = "kingOfTheHill"
-	RacingKings   Variant = "racingKings"
-	ThreeCheck    Variant = "threeCheck"
-	FromPosition  Variant = "fromPosition"
+	Standard      = model.Standard
+	Chess960      = model.Chess960
+	Crazyhouse    = model.Crazyhouse
+	Antichess     = model.Antichess
+	Atomic        = model.Atomic
+	Horde         = model.Horde
+	KingOfTheHill = model.KingOfTheHill
+	RacingKings   = model.RacingKings
+	ThreeCheck    = model.ThreeCheck
+	FromPosition  = model.FromPosition
 )
 
-var VariantValue = map[string]Variant{
-	"standard":      Standard,
-	"chess960":      Chess960,
-	"crazyhouse":    Crazyhouse,
-	"antichess":     Antichess,
-	"atomic":        Atomic,
-	"horde":         Horde,
-	"kingOfTheHill": KingOfTheHill,
-	"racingKings":   RacingKings,
-	"threeCheck":    ThreeCheck,
-	"fromPosition":  FromPosition,
-}
+var VariantValue = model.VariantValue
 
 type Rules string
 
@@ -124,19 +117,15 @@ var RulesValue = map[string]Rules{
 	"noEarlyDraw": NoEarlyDraw,
 }
 
-type Color string
+type Color = model.Color
 
 const (
-	White  Color = "white"
-	Black  Color = "black"
-	Random Color = "random"
+	White  = model.White
+	Black  = model.Black
+	Random = model.Random
 )
 
-var ColorValue = map[string]Color{
-	"white":  White,
-	"black":  Black,
-	"random": Random,
-}
+var ColorValue = model.ColorValue
 
 type TestPlayerRequest struct {
 	Elo               float64                  `json:"elo"`
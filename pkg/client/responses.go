@@ -16,3 +16,8 @@ type TestPairResponse struct {
 	Team1 []model.Ticket `json:"team1"`
 	Team2 []model.Ticket `json:"team2"`
 }
+
+type LobbyInfoResponse struct {
+	MatchId     string      `json:"matchId"`
+	LobbyConfig LobbyConfig `json:"lobbyConfig"`
+}
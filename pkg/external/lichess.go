@@ -4,8 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"mmf/config"
+	"mmf/internal/metrics"
 	"net/http"
 )
 
@@ -47,57 +48,66 @@ type LichessAccount struct {
 }
 
 func GetGlicko(apiKey, perf string) (int, error) {
+	perfs, err := GetLichessPerfs(apiKey)
+	if err != nil {
+		return 0, err
+	}
+
+	prf, ok := perfs[perf]
+	if !ok {
+		return 0, fmt.Errorf("no performance data for %s", perf)
+	}
+
+	slog.Debug("Lichess rating", "perf", perf, "rating", prf.Rating)
+
+	return prf.Rating, nil
+}
+
+// GetLichessPerfs returns ratings of the account for every perf (speeds and variants)
+func GetLichessPerfs(apiKey string) (map[string]Performance, error) {
 
 	if apiKey == "" {
-		return 0, fmt.Errorf("LICHESS_API_KEY not found in environment variables")
+		return nil, fmt.Errorf("LICHESS_API_KEY not found in environment variables")
 	}
 
 	// url := fmt.Sprintf("https://lichess.org/api/user/%s/perf/%s", username, perf)
 	url := config.GlobalConfig.LichessApi.URL + "/api/account"
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return 0, fmt.Errorf("error creating request: %v", err)
+		return nil, fmt.Errorf("error creating request: %v", err)
 	}
 
 	req.Header.Set("Authorization", "Bearer "+apiKey)
 
-	client := &http.Client{}
+	client := metrics.HTTPClient("lichess")
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, fmt.Errorf("error performing request: %v", err)
+		return nil, fmt.Errorf("error performing request: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("received non-OK response: %v", resp.Status)
+		return nil, fmt.Errorf("received non-OK response: %v", resp.Status)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return 0, fmt.Errorf("error reading response body: %v", err)
+		return nil, fmt.Errorf("error reading response body: %v", err)
 	}
 
 	var lr LichessAccount
 	err = json.Unmarshal(body, &lr)
 	if err != nil {
-		return 0, fmt.Errorf("error parsing JSON response: %v", err)
-	}
-
-	prf, ok := lr.Perfs[perf]
-	if !ok {
-		return 0, fmt.Errorf("no performance data for %s", perf)
+		return nil, fmt.Errorf("error parsing JSON response: %v", err)
 	}
 
-	log.Println("Rating for ", apiKey, " is ", prf.Rating)
-
-	return prf.Rating, nil
+	return lr.Perfs, nil
 }
 
 func GetLichessUsername(apiKey string) (string, error) {
 	if apiKey == "" {
 		return "", fmt.Errorf("LICHESS_API_KEY not found in environment variables")
 	}
-	log.Print(apiKey)
 	// url := fmt.Sprintf("https://lichess.org/api/user/%s/perf/%s", username, perf)
 	url := config.GlobalConfig.LichessApi.URL + "/api/account"
 	req, err := http.NewRequest("GET", url, nil)
@@ -107,7 +117,7 @@ func GetLichessUsername(apiKey string) (string, error) {
 
 	req.Header.Set("Authorization", "Bearer "+apiKey)
 
-	client := &http.Client{}
+	client := metrics.HTTPClient("lichess")
 	resp, err := client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("error performing request: %v", err)
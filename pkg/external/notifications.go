@@ -2,10 +2,12 @@ package external
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"mmf/config"
+	"mmf/internal/metrics"
 	"net/http"
 )
 
@@ -25,23 +27,30 @@ type Notification struct {
 	Service  string      `json:"service"`
 }
 
-func SendNotification(notification Notification) {
+func SendNotification(ctx context.Context, notification Notification) {
 	jsonData, err := json.Marshal(notification)
 	if err != nil {
-		log.Println("Error marshalling data: ", err)
+		slog.Error("Error marshalling notification", "error", err)
 	}
 
 	url := config.GlobalConfig.Notifications.URL + "/v1/notification"
 
 	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		log.Printf("Error calling %s: %s", url, err.Error())
+		slog.Error("Error creating notification request", "url", url, "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := metrics.HTTPClient("notifications").Do(req)
+	if err != nil {
+		slog.Error("Error sending notification", "url", url, "error", err)
 		return
 	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		log.Printf("Error calling %s: %d", url, resp.StatusCode)
+		slog.Error("Error sending notification", "url", url, "status", resp.StatusCode)
 	}
 
-	log.Printf("Sent event on... %s", url)
+	slog.Debug("Notification sent", "url", url, "refId", notification.RefId)
 }
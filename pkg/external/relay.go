@@ -2,21 +2,21 @@ package external
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"mmf/config"
+	"mmf/internal/metrics"
 	"mmf/internal/model"
-	"net/http"
 )
 
 func GetDataFromRelay(steamId string) *model.EloData {
 	relayAddress := config.GlobalConfig.ShowdownStatsRelay.URL
-	resp, err := http.Get(relayAddress + "/statistics/elo/" + steamId)
+	resp, err := metrics.HTTPClient("stats_relay").Get(relayAddress + "/statistics/elo/" + steamId)
 	if err != nil {
-		log.Println("Error getting elo from relay")
+		slog.Warn("Error getting elo from relay, using default elo 1500", "userId", steamId, "error", err)
 		return &model.EloData{Elo: 1500}
 	}
 	if resp.StatusCode != 200 {
-		log.Println("Error getting elo from relay")
+		slog.Warn("Error getting elo from relay, using default elo 1500", "userId", steamId, "status", resp.StatusCode)
 		return &model.EloData{Elo: 1500}
 	}
 
@@ -25,7 +25,7 @@ func GetDataFromRelay(steamId string) *model.EloData {
 	defer resp.Body.Close()
 	err = json.NewDecoder(resp.Body).Decode(&eloData)
 	if err != nil {
-		log.Println("Error decoding elo data")
+		slog.Warn("Error decoding elo data, using default elo 1500", "userId", steamId, "error", err)
 		return &model.EloData{Elo: 1500}
 	}
 	return &eloData
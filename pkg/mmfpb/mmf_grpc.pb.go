@@ -0,0 +1,426 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             v5.27.1
+// source: mmf.proto
+
+package mmfpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	Matchmaker_SubmitTicket_FullMethodName  = "/mmf.v1.Matchmaker/SubmitTicket"
+	Matchmaker_CancelTicket_FullMethodName  = "/mmf.v1.Matchmaker/CancelTicket"
+	Matchmaker_RefreshTicket_FullMethodName = "/mmf.v1.Matchmaker/RefreshTicket"
+	Matchmaker_WatchMatches_FullMethodName  = "/mmf.v1.Matchmaker/WatchMatches"
+	Matchmaker_GetQueueStats_FullMethodName = "/mmf.v1.Matchmaker/GetQueueStats"
+	Matchmaker_ListTickets_FullMethodName   = "/mmf.v1.Matchmaker/ListTickets"
+	Matchmaker_ClearQueue_FullMethodName    = "/mmf.v1.Matchmaker/ClearQueue"
+	Matchmaker_CancelMatch_FullMethodName   = "/mmf.v1.Matchmaker/CancelMatch"
+)
+
+// MatchmakerClient is the client API for Matchmaker service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Matchmaker lets backend services create tickets on behalf of players and
+// follow the match lifecycle
+type MatchmakerClient interface {
+	// Adds a ticket for the player, the player accepts and pays the match over
+	// the websocket, SSE or REST flow
+	SubmitTicket(ctx context.Context, in *SubmitTicketRequest, opts ...grpc.CallOption) (*SubmitTicketResponse, error)
+	CancelTicket(ctx context.Context, in *CancelTicketRequest, opts ...grpc.CallOption) (*CancelTicketResponse, error)
+	// Refreshes the heartbeat of a ticket, tickets submitted with SubmitTicket don't expire
+	// seconds unless refreshed or the player has a session on the queue
+	RefreshTicket(ctx context.Context, in *RefreshTicketRequest, opts ...grpc.CallOption) (*RefreshTicketResponse, error)
+	// Streams match lifecycle events from now on, filters are optional
+	WatchMatches(ctx context.Context, in *WatchMatchesRequest, opts ...grpc.CallOption) (Matchmaker_WatchMatchesClient, error)
+	GetQueueStats(ctx context.Context, in *GetQueueStatsRequest, opts ...grpc.CallOption) (*QueueStats, error)
+	// Admin operations
+	ListTickets(ctx context.Context, in *ListTicketsRequest, opts ...grpc.CallOption) (*ListTicketsResponse, error)
+	ClearQueue(ctx context.Context, in *ClearQueueRequest, opts ...grpc.CallOption) (*ClearQueueResponse, error)
+	// Cancels a match waiting for players to accept or pay, players that
+	// didn't decline go back to the queue unless remove_players is set
+	CancelMatch(ctx context.Context, in *CancelMatchRequest, opts ...grpc.CallOption) (*CancelMatchResponse, error)
+}
+
+type matchmakerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMatchmakerClient(cc grpc.ClientConnInterface) MatchmakerClient {
+	return &matchmakerClient{cc}
+}
+
+func (c *matchmakerClient) SubmitTicket(ctx context.Context, in *SubmitTicketRequest, opts ...grpc.CallOption) (*SubmitTicketResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SubmitTicketResponse)
+	err := c.cc.Invoke(ctx, Matchmaker_SubmitTicket_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *matchmakerClient) CancelTicket(ctx context.Context, in *CancelTicketRequest, opts ...grpc.CallOption) (*CancelTicketResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CancelTicketResponse)
+	err := c.cc.Invoke(ctx, Matchmaker_CancelTicket_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *matchmakerClient) RefreshTicket(ctx context.Context, in *RefreshTicketRequest, opts ...grpc.CallOption) (*RefreshTicketResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RefreshTicketResponse)
+	err := c.cc.Invoke(ctx, Matchmaker_RefreshTicket_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *matchmakerClient) WatchMatches(ctx context.Context, in *WatchMatchesRequest, opts ...grpc.CallOption) (Matchmaker_WatchMatchesClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Matchmaker_ServiceDesc.Streams[0], Matchmaker_WatchMatches_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &matchmakerWatchMatchesClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Matchmaker_WatchMatchesClient interface {
+	Recv() (*MatchEvent, error)
+	grpc.ClientStream
+}
+
+type matchmakerWatchMatchesClient struct {
+	grpc.ClientStream
+}
+
+func (x *matchmakerWatchMatchesClient) Recv() (*MatchEvent, error) {
+	m := new(MatchEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *matchmakerClient) GetQueueStats(ctx context.Context, in *GetQueueStatsRequest, opts ...grpc.CallOption) (*QueueStats, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(QueueStats)
+	err := c.cc.Invoke(ctx, Matchmaker_GetQueueStats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *matchmakerClient) ListTickets(ctx context.Context, in *ListTicketsRequest, opts ...grpc.CallOption) (*ListTicketsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListTicketsResponse)
+	err := c.cc.Invoke(ctx, Matchmaker_ListTickets_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *matchmakerClient) ClearQueue(ctx context.Context, in *ClearQueueRequest, opts ...grpc.CallOption) (*ClearQueueResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ClearQueueResponse)
+	err := c.cc.Invoke(ctx, Matchmaker_ClearQueue_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *matchmakerClient) CancelMatch(ctx context.Context, in *CancelMatchRequest, opts ...grpc.CallOption) (*CancelMatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CancelMatchResponse)
+	err := c.cc.Invoke(ctx, Matchmaker_CancelMatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MatchmakerServer is the server API for Matchmaker service.
+// All implementations must embed UnimplementedMatchmakerServer
+// for forward compatibility
+//
+// Matchmaker lets backend services create tickets on behalf of players and
+// follow the match lifecycle
+type MatchmakerServer interface {
+	// Adds a ticket for the player, the player accepts and pays the match over
+	// the websocket, SSE or REST flow
+	SubmitTicket(context.Context, *SubmitTicketRequest) (*SubmitTicketResponse, error)
+	CancelTicket(context.Context, *CancelTicketRequest) (*CancelTicketResponse, error)
+	// Refreshes the heartbeat of a ticket, tickets submitted with SubmitTicket don't expire
+	// seconds unless refreshed or the player has a session on the queue
+	RefreshTicket(context.Context, *RefreshTicketRequest) (*RefreshTicketResponse, error)
+	// Streams match lifecycle events from now on, filters are optional
+	WatchMatches(*WatchMatchesRequest, Matchmaker_WatchMatchesServer) error
+	GetQueueStats(context.Context, *GetQueueStatsRequest) (*QueueStats, error)
+	// Admin operations
+	ListTickets(context.Context, *ListTicketsRequest) (*ListTicketsResponse, error)
+	ClearQueue(context.Context, *ClearQueueRequest) (*ClearQueueResponse, error)
+	// Cancels a match waiting for players to accept or pay, players that
+	// didn't decline go back to the queue unless remove_players is set
+	CancelMatch(context.Context, *CancelMatchRequest) (*CancelMatchResponse, error)
+	mustEmbedUnimplementedMatchmakerServer()
+}
+
+// UnimplementedMatchmakerServer must be embedded to have forward compatible implementations.
+type UnimplementedMatchmakerServer struct {
+}
+
+func (UnimplementedMatchmakerServer) SubmitTicket(context.Context, *SubmitTicketRequest) (*SubmitTicketResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitTicket not implemented")
+}
+func (UnimplementedMatchmakerServer) CancelTicket(context.Context, *CancelTicketRequest) (*CancelTicketResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelTicket not implemented")
+}
+func (UnimplementedMatchmakerServer) RefreshTicket(context.Context, *RefreshTicketRequest) (*RefreshTicketResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RefreshTicket not implemented")
+}
+func (UnimplementedMatchmakerServer) WatchMatches(*WatchMatchesRequest, Matchmaker_WatchMatchesServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchMatches not implemented")
+}
+func (UnimplementedMatchmakerServer) GetQueueStats(context.Context, *GetQueueStatsRequest) (*QueueStats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetQueueStats not implemented")
+}
+func (UnimplementedMatchmakerServer) ListTickets(context.Context, *ListTicketsRequest) (*ListTicketsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTickets not implemented")
+}
+func (UnimplementedMatchmakerServer) ClearQueue(context.Context, *ClearQueueRequest) (*ClearQueueResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClearQueue not implemented")
+}
+func (UnimplementedMatchmakerServer) CancelMatch(context.Context, *CancelMatchRequest) (*CancelMatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelMatch not implemented")
+}
+func (UnimplementedMatchmakerServer) mustEmbedUnimplementedMatchmakerServer() {}
+
+// UnsafeMatchmakerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MatchmakerServer will
+// result in compilation errors.
+type UnsafeMatchmakerServer interface {
+	mustEmbedUnimplementedMatchmakerServer()
+}
+
+func RegisterMatchmakerServer(s grpc.ServiceRegistrar, srv MatchmakerServer) {
+	s.RegisterService(&Matchmaker_ServiceDesc, srv)
+}
+
+func _Matchmaker_SubmitTicket_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitTicketRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchmakerServer).SubmitTicket(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Matchmaker_SubmitTicket_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchmakerServer).SubmitTicket(ctx, req.(*SubmitTicketRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Matchmaker_CancelTicket_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelTicketRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchmakerServer).CancelTicket(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Matchmaker_CancelTicket_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchmakerServer).CancelTicket(ctx, req.(*CancelTicketRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Matchmaker_RefreshTicket_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RefreshTicketRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchmakerServer).RefreshTicket(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Matchmaker_RefreshTicket_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchmakerServer).RefreshTicket(ctx, req.(*RefreshTicketRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Matchmaker_WatchMatches_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchMatchesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MatchmakerServer).WatchMatches(m, &matchmakerWatchMatchesServer{ServerStream: stream})
+}
+
+type Matchmaker_WatchMatchesServer interface {
+	Send(*MatchEvent) error
+	grpc.ServerStream
+}
+
+type matchmakerWatchMatchesServer struct {
+	grpc.ServerStream
+}
+
+func (x *matchmakerWatchMatchesServer) Send(m *MatchEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Matchmaker_GetQueueStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetQueueStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchmakerServer).GetQueueStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Matchmaker_GetQueueStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchmakerServer).GetQueueStats(ctx, req.(*GetQueueStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Matchmaker_ListTickets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTicketsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchmakerServer).ListTickets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Matchmaker_ListTickets_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchmakerServer).ListTickets(ctx, req.(*ListTicketsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Matchmaker_ClearQueue_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClearQueueRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchmakerServer).ClearQueue(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Matchmaker_ClearQueue_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchmakerServer).ClearQueue(ctx, req.(*ClearQueueRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Matchmaker_CancelMatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelMatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchmakerServer).CancelMatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Matchmaker_CancelMatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchmakerServer).CancelMatch(ctx, req.(*CancelMatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Matchmaker_ServiceDesc is the grpc.ServiceDesc for Matchmaker service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Matchmaker_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "mmf.v1.Matchmaker",
+	HandlerType: (*MatchmakerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SubmitTicket",
+			Handler:    _Matchmaker_SubmitTicket_Handler,
+		},
+		{
+			MethodName: "CancelTicket",
+			Handler:    _Matchmaker_CancelTicket_Handler,
+		},
+		{
+			MethodName: "RefreshTicket",
+			Handler:    _Matchmaker_RefreshTicket_Handler,
+		},
+		{
+			MethodName: "GetQueueStats",
+			Handler:    _Matchmaker_GetQueueStats_Handler,
+		},
+		{
+			MethodName: "ListTickets",
+			Handler:    _Matchmaker_ListTickets_Handler,
+		},
+		{
+			MethodName: "ClearQueue",
+			Handler:    _Matchmaker_ClearQueue_Handler,
+		},
+		{
+			MethodName: "CancelMatch",
+			Handler:    _Matchmaker_CancelMatch_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchMatches",
+			Handler:       _Matchmaker_WatchMatches_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "mmf.proto",
+}
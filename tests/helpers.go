@@ -19,19 +19,44 @@ func callWS(url string) (*websocket.Conn, error) {
 		return nil, err
 	}
 
-	_, mess, err := wsConn.ReadMessage()
+	// Match state is sent right after connecting
+	matchState, err := readServerMessage(wsConn)
 	if err != nil {
 		return nil, err
 	}
+	if matchState.EventType != ws.MatchState {
+		return nil, errors.New("invalid message")
+	}
+
+	if err := wsConn.WriteJSON(ws.ClientMessage{Version: ws.ProtocolVersion, RequestId: "join", Type: ws.JoinQueue, Payload: []byte("{}")}); err != nil {
+		return nil, err
+	}
 
-	// if mess doesn't start with "Hello," then return error
-	if !strings.HasPrefix(string(mess), "Hello,") {
+	joined, err := readServerMessage(wsConn)
+	if err != nil {
+		return nil, err
+	}
+	if joined.RequestId != "join" || joined.Message != "Joined queue" {
 		return nil, errors.New("invalid message")
 	}
 
 	return wsConn, nil
 }
 
+func readServerMessage(wsConn *websocket.Conn) (*ws.ServerMessage, error) {
+	_, mess, err := wsConn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	var serverMessage ws.ServerMessage
+	if err := json.Unmarshal(mess, &serverMessage); err != nil {
+		return nil, err
+	}
+
+	return &serverMessage, nil
+}
+
 func getMatchId(wsConn *websocket.Conn) (*string, error) {
 	_, mess, err := wsConn.ReadMessage()
 	if err != nil {
@@ -39,7 +64,9 @@ func getMatchId(wsConn *websocket.Conn) (*string, error) {
 	}
 
 	var matchId struct {
-		MatchId string `json:"matchId"`
+		Message struct {
+			MatchId string `json:"matchId"`
+		} `json:"message"`
 	}
 
 	err = json.Unmarshal(mess, &matchId)
@@ -47,7 +74,7 @@ func getMatchId(wsConn *websocket.Conn) (*string, error) {
 		return nil, err
 	}
 
-	return &matchId.MatchId, err
+	return &matchId.Message.MatchId, err
 }
 
 func sendResponseWS(wsConn *websocket.Conn, resp ws.UserResponse) error {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"mmf/config"
+	"mmf/internal/clock"
 	"mmf/internal/model"
 	"mmf/internal/redis"
 	"mmf/internal/server"
@@ -24,6 +25,8 @@ import (
 
 var (
 	testServer *httptest.Server
+	// Crawler and match timeouts only move when the tests advance it
+	testClock = clock.NewFake(time.Unix(1700000000, 0))
 )
 
 func TestMain(m *testing.M) {
@@ -35,7 +38,7 @@ func TestMain(m *testing.M) {
 var wsURL string
 
 func setup() {
-	config := &config.Config{
+	cfg := &config.Config{
 		Redis: config.RedisConfig{
 			Host:     "localhost",
 			Port:     "6378",
@@ -48,12 +51,21 @@ func setup() {
 			TeamSize:          1,
 			Treshold:          0.8,
 			TimeToCancelMatch: 15,
+			TimeToAccept:      10,
+			TicketTTL:         30,
+		},
+		History: config.HistoryConfig{
+			RetentionDays:       365,
+			ResultRetentionDays: 90,
 		},
 	}
+	config.GlobalConfig = cfg
 
-	server.InitCrawler(config.MMRConfig)
-	redis.Init(config, context.Background())
-	wires.Init(config)
+	clock.Set(testClock)
+	server.InitCrawler(cfg.MMRConfig)
+	redis.Init(cfg, context.Background())
+	wires.Init(cfg)
+	ws.StartTicketHeartbeat(cfg.MMRConfig.TicketTTL)
 
 	r := gin.Default()
 	server.RegisterVersion(r, context.Background())
@@ -62,9 +74,15 @@ func setup() {
 	wsURL = strings.Replace(testServer.URL, "http", "ws", 1) + "/ws"
 }
 
+// runCrawler advances the test clock by the crawler interval, tickets in the
+// queue are evaluated right after
+func runCrawler() {
+	testClock.Advance(time.Duration(config.GlobalConfig.MMRConfig.Interval) * time.Second)
+}
+
 func TestFetchTickets(t *testing.T) {
 	t.Log("Test Fetch Tickets")
-	wsConn, err := callWS(wsURL + "/d2queue/1")
+	wsConn, err := callWS(wsURL + "/d2queue/1/0x1")
 	assert.NoError(t, err, "Error connecting to WebSocket")
 	defer wsConn.Close()
 
@@ -85,7 +103,7 @@ func TestFetchTickets(t *testing.T) {
 func TestWebsocketsConnection(t *testing.T) {
 	t.Log("Test Websockets Connection")
 
-	url := wsURL + "/d2queue/1"
+	url := wsURL + "/d2queue/1/0x1"
 	wsConn, err := callWS(url)
 	assert.NoError(t, err, "Error connecting to WebSocket")
 	defer wsConn.Close()
@@ -95,7 +113,7 @@ func TestWebsocketsConnection(t *testing.T) {
 	// Start reading messages from the WebSocket in a goroutine
 	go func() {
 		defer close(done)
-		_, message, err := wsConn.ReadMessage()
+		message, err := readServerMessage(wsConn)
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				t.Logf("Error: %v", err)
@@ -103,8 +121,8 @@ func TestWebsocketsConnection(t *testing.T) {
 			return // return or break based on your error handling
 		}
 
-		expectedMessage := "Hello, 1"
-		assert.Equal(t, expectedMessage, string(message), "Unexpected message received")
+		assert.Equal(t, ws.Error, message.EventType, "Unexpected message received")
+		assert.Equal(t, ws.InvalidMessage, message.Error.Code, "Unexpected error code")
 	}()
 
 	// Write a message (if your test case requires sending a message to the server)
@@ -125,15 +143,15 @@ func TestWebsocketsConnection(t *testing.T) {
 
 func TestMatchMakingFlowEveryoneAccepts(t *testing.T) {
 	t.Log("Test MatchMaking Flow Everyone Accepts")
-	wsConn1, err := callWS(wsURL + "/d2queue/1")
+	wsConn1, err := callWS(wsURL + "/d2queue/1/0x1")
 	assert.NoError(t, err, "Error connecting to WebSocket 1")
 	defer wsConn1.Close()
 
-	wsConn2, err := callWS(wsURL + "/d2queue/2")
+	wsConn2, err := callWS(wsURL + "/d2queue/2/0x2")
 	assert.NoError(t, err, "Error connecting to WebSocket 2")
 	defer wsConn2.Close()
 
-	time.Sleep(1 * time.Second)
+	runCrawler()
 
 	// Get matchId from both connections
 	matchId1, err := getMatchId(wsConn1)
@@ -154,20 +172,31 @@ func TestMatchMakingFlowEveryoneAccepts(t *testing.T) {
 	err = sendResponseWS(wsConn2, ws.UserResponse{MatchId: *matchId2, Option: 2})
 	assert.NoError(t, err, "Error sending response to WebSocket 2")
 
+	// Team matches aren't created on chain, once accepted they wait for the payment
+	assert.Eventually(t, func() bool {
+		testClock.Advance(2 * time.Second)
+		userState := ws.GetUserState("1")
+		return userState != nil && userState.State == model.PaymentPending && userState.MatchId == *matchId1
+	}, 3*time.Second, 50*time.Millisecond, "Match should be waiting for the payment")
+
 	redis.RedisClient.FlushAll()
 }
 
 func TestOneGuysDoesntRespond(t *testing.T) {
 	t.Log("Test One Guys Doesn't Respond")
-	wsConn1, err := callWS(wsURL + "/d2queue/3")
+	wsConn1, err := callWS(wsURL + "/d2queue/3/0x3")
 	assert.NoError(t, err, "Error connecting to WebSocket 3")
 	defer wsConn1.Close()
 
-	wsConn2, err := callWS(wsURL + "/d2queue/4")
+	wsConn2, err := callWS(wsURL + "/d2queue/4/0x4")
 	assert.NoError(t, err, "Error connecting to WebSocket 4")
 	defer wsConn2.Close()
 
-	time.Sleep(1 * time.Second)
+	ticket := wires.Instance.TicketService.GetTicket("d2queue", "3")
+	assert.NotNil(t, ticket, "Ticket of player 3 should be in the queue")
+	joinedAt := ticket.Member.JoinedAt
+
+	runCrawler()
 
 	// Get matchId from both connections
 	matchId1, err := getMatchId(wsConn1)
@@ -181,14 +210,38 @@ func TestOneGuysDoesntRespond(t *testing.T) {
 	err = sendResponseWS(wsConn1, ws.UserResponse{MatchId: *matchId1, Option: 2})
 	assert.NoError(t, err, "Error sending response to WebSocket 1")
 
-	// Wait for the match to be cancelled
-	time.Sleep(20 * time.Second)
-	matchExists := redis.RedisClient.Exists(*matchId1)
-	assert.Equal(t, int64(0), matchExists.Val(), "Match should have been cancelled")
+	// The match waits for the second player until the accept deadline
+	testClock.Advance(8 * time.Second)
+	assert.Equal(t, int64(1), redis.RedisClient.Exists(*matchId1).Val(), "Match shouldn't be cancelled before the deadline")
+
+	testClock.Advance(4 * time.Second)
+	assert.Eventually(t, func() bool {
+		return redis.RedisClient.Exists(*matchId1).Val() == 0
+	}, 3*time.Second, 50*time.Millisecond, "Match should have been cancelled")
 
 	firstPlayerBackToQueue := redis.RedisClient.ZRangeWithScores("d2queue", 0, -1)
 	t.Log(firstPlayerBackToQueue.Val())
 	assert.NoError(t, firstPlayerBackToQueue.Err(), "Error fetching player from queue")
 
+	// The player who accepted keeps their place, the wait counts from the first join
+	requeued := wires.Instance.TicketService.GetTicket("d2queue", "3")
+	if assert.NotNil(t, requeued, "Player who accepted should be back in the queue") {
+		assert.Equal(t, joinedAt, requeued.Member.JoinedAt, "Requeued ticket should keep the original join time")
+	}
+
+	redis.RedisClient.FlushAll()
+}
+
+func TestStaleTicketIsReaped(t *testing.T) {
+	t.Log("Test Stale Ticket Is Reaped")
+	// Ticket without a session, like one left behind by a crashed replica
+	_, err := wires.Instance.TicketService.SubmitTicket(context.Background(), model.SubmitTicketRequest{Id: "5", Elo: 1500, WalletAddress: "0x5"}, "d2queue")
+	assert.NoError(t, err, "Error submitting ticket")
+
+	testClock.Advance(31 * time.Second)
+	assert.Eventually(t, func() bool {
+		return wires.Instance.TicketService.GetTicket("d2queue", "5") == nil
+	}, 3*time.Second, 50*time.Millisecond, "Stale ticket should have been removed")
+
 	redis.RedisClient.FlushAll()
 }
@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"math"
+	"mmf/config"
+	"mmf/internal/clock"
+	"mmf/internal/constants"
+	"mmf/internal/logging"
+	"mmf/internal/model"
+	"mmf/internal/redis"
+	ws "mmf/internal/server/websockets"
+	"mmf/internal/wires"
+	"time"
+)
+
+// Only team matches are backfilled, 1v1 matches are cheap to recreate
+func canBackfill(queue constants.QueueType) bool {
+	mmCfg := config.GlobalConfig.MMRConfig
+	return mmCfg.Backfill && mmCfg.TeamSize > 1 && !constants.IsLichessQueue(queue.String())
+}
+
+// backfillPlayer removes the player who declined, or didn't answer, from the
+// match and waits for the best fitting replacement from the queue, accepted
+// players stay reserved meanwhile. Returns nil if nobody suitable joined in time.
+func backfillPlayer(queue constants.QueueType, region constants.Region, matchId string, declined *model.MatchPlayer, reason string) *model.Ticket {
+	mmCfg := config.GlobalConfig.MMRConfig
+
+	redis.RedisClient.HDel(matchId, declined.Id)
+	if err := DeleteUserState(declined.Id); err != nil {
+		logging.Match(matchId, queue.String()).Error("Error deleting user state", "userId", declined.Id, "error", err)
+	}
+	ws.SendMessageToUser(declined.Id, ws.Removed, reason)
+
+	deadline := clock.Now().Add(time.Duration(mmCfg.BackfillTime) * time.Second)
+	ticker := clock.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if replacement := findReplacement(queue, region, declined, mmCfg); replacement != nil {
+			removed, err := wires.Instance.TicketService.RemoveTickets(queue.String(), []model.MemberData{replacement.Member})
+			if err != nil {
+				logging.Match(matchId, queue.String()).Error("Error removing backfill ticket from queue", "userId", replacement.Member.Id, "error", err)
+				return nil
+			}
+			if len(removed) == 0 {
+				// Taken by another match meanwhile, look again on the next tick
+				<-ticker.C()
+				continue
+			}
+			replacement.Role = declined.Role
+
+			matchPlayer := model.MatchPlayer{
+				Id:                replacement.Member.Id,
+				Option:            1,
+				Team:              declined.Team,
+				Score:             replacement.Score,
+				WalletAddress:     replacement.Member.WalletAddress,
+				LichessCustomData: replacement.Member.LichessCustomData,
+				Regions:           replacement.Member.Regions,
+				Pings:             replacement.Member.Pings,
+				Roles:             replacement.Member.Roles,
+				Role:              replacement.Role,
+				NoExpiry:          replacement.Member.NoExpiry,
+				JoinedAt:          replacement.Member.JoinedAt,
+			}
+			if err := SetMatchInfoInRedis(matchId, matchPlayer.Id, &matchPlayer); err != nil {
+				logging.Match(matchId, queue.String()).Error("Error adding backfill player to match", "userId", replacement.Member.Id, "error", err)
+				return nil
+			}
+
+			logging.Match(matchId, queue.String()).Info("Player replaced", "userId", replacement.Member.Id, "replacedUserId", declined.Id)
+			return replacement
+		}
+
+		if clock.Now().After(deadline) {
+			return nil
+		}
+		<-ticker.C()
+	}
+}
+
+// Closest rated ticket in the queue within MMR range that can play in the region
+func findReplacement(queue constants.QueueType, region constants.Region, declined *model.MatchPlayer, mmCfg config.MMRConfig) *model.Ticket {
+	tickets := wires.Instance.TicketService.GetAllTickets(queue.String())
+	if tickets == nil {
+		return nil
+	}
+	*tickets = wires.Instance.TicketService.FilterLiveTickets(queue.String(), *tickets)
+
+	now := clock.Now().Unix()
+	var best *model.Ticket
+	bestDiff := float64(mmCfg.Range)
+	for i, ticket := range *tickets {
+		if ticket.Member.Id == declined.Id {
+			continue
+		}
+
+		if region != "" && !ticket.Member.AcceptsRegion(region, mmCfg.RegionRelaxTime, now) {
+			continue
+		}
+
+		if ping, ok := ticket.Member.Pings[region.String()]; ok && mmCfg.MaxPing > 0 && ping > mmCfg.MaxPing {
+			continue
+		}
+
+		if diff := math.Abs(ticket.Score - declined.Score); diff <= bestDiff {
+			bestDiff = diff
+			best = &(*tickets)[i]
+		}
+	}
+
+	return best
+}
+
+// Puts the replacement in place of the declined player
+func replaceTicket(tickets []model.Ticket, declinedId string, replacement model.Ticket) []model.Ticket {
+	replaced := make([]model.Ticket, 0, len(tickets))
+	for _, ticket := range tickets {
+		if ticket.Member.Id == declinedId {
+			ticket = replacement
+		}
+		replaced = append(replaced, ticket)
+	}
+	return replaced
+}
+
+// extendAcceptWindow moves the accept deadline of every player of the match
+// and sends it to them, answers given already are kept
+func extendAcceptWindow(queue constants.QueueType, matchId string, tickets []model.Ticket, expiry int64) {
+	userState := model.UserGlobalState{State: model.MatchFound, MatchId: matchId, ExpiryTime: expiry}
+	for _, ticket := range tickets {
+		if err := SetUserStateInRedis(ticket.Member.Id, &userState); err != nil {
+			logging.Match(matchId, queue.String()).Error("Error setting user state to match found", "userId", ticket.Member.Id, "error", err)
+		}
+	}
+	ws.SendMatchFoundToPlayers(matchId, tickets, expiry)
+}
@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mmf/internal/audit"
+	"mmf/internal/constants"
+	"mmf/internal/events"
+	"mmf/internal/history"
+	"mmf/internal/logging"
+	"mmf/internal/model"
+	"mmf/internal/redis"
+	ws "mmf/internal/server/websockets"
+	"sync"
+
+	goredis "github.com/go-redis/redis"
+)
+
+// Matches that can still be cancelled, i.e. not yet being scheduled on the
+// game server, have a key in Redis so any replica can cancel them. The key
+// holds "pending" until the match is cancelled or locked in, the cancellation
+// is then published to every replica and the one running the match thread
+// stops it.
+const (
+	cancellableMatchPrefix = "cancellable_match_"
+	matchCancelChannel     = "match_cancel"
+
+	matchPending = "pending"
+	matchRequeue = "requeue"
+	matchRemove  = "remove"
+)
+
+// cancelScript marks a pending match as cancelled with the mode in ARGV[1],
+// returns 0 when the match isn't pending
+var cancelScript = goredis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= 'pending' then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'EX', ARGV[2])
+return 1
+`)
+
+// lockInScript removes the key of the match and returns what it held
+var lockInScript = goredis.NewScript(`
+local state = redis.call('GET', KEYS[1])
+redis.call('DEL', KEYS[1])
+return state
+`)
+
+// Match threads of this replica waiting for a cancellation
+var cancellations = make(map[string]*cancellation)
+var cancellationsMutex sync.Mutex
+var subscribeCancellations sync.Once
+
+type cancellation struct {
+	done    chan struct{} // Closed on cancel
+	requeue bool          // Players that didn't decline go back to the queue, set before done is closed
+}
+
+type cancelMessage struct {
+	MatchId string `json:"matchId"`
+	Requeue bool   `json:"requeue"`
+}
+
+// registerMatch makes the match cancellable
+func registerMatch(matchId string) *cancellation {
+	subscribeCancellations.Do(listenForCancellations)
+
+	cancel := &cancellation{done: make(chan struct{})}
+	cancellationsMutex.Lock()
+	cancellations[matchId] = cancel
+	cancellationsMutex.Unlock()
+
+	if err := redis.RedisClient.Set(cancellableMatchPrefix+matchId, matchPending, activeMatchLifetime).Err(); err != nil {
+		slog.Error("Error making match cancellable", "matchId", matchId, "error", err)
+	}
+	return cancel
+}
+
+// lockInMatch makes the match no longer cancellable. It returns false if the
+// match was cancelled already, along with whether its players are requeued.
+func lockInMatch(matchId string) (bool, bool) {
+	cancellationsMutex.Lock()
+	delete(cancellations, matchId)
+	cancellationsMutex.Unlock()
+
+	state, err := lockInScript.Run(redis.RedisClient, []string{cancellableMatchPrefix + matchId}).Result()
+	if err != nil && err != goredis.Nil {
+		slog.Error("Error locking in match", "matchId", matchId, "error", err)
+		return true, false
+	}
+	switch state {
+	case matchRequeue:
+		return false, true
+	case matchRemove:
+		return false, false
+	default:
+		return true, false
+	}
+}
+
+// CancelMatch stops a match waiting for players to accept or pay, on any
+// replica. The match thread clears it and, with requeue, returns the players
+// that didn't decline to matchmaking.
+func CancelMatch(matchId string, requeue bool) error {
+	mode := matchRemove
+	if requeue {
+		mode = matchRequeue
+	}
+
+	cancelled, err := cancelScript.Run(redis.RedisClient, []string{cancellableMatchPrefix + matchId}, mode, int(activeMatchLifetime.Seconds())).Int64()
+	if err != nil {
+		return fmt.Errorf("error cancelling match %s - %s", matchId, err)
+	}
+	if cancelled == 0 {
+		return fmt.Errorf("match %s not found or already scheduled", matchId)
+	}
+
+	encoded, _ := json.Marshal(cancelMessage{MatchId: matchId, Requeue: requeue})
+	return redis.RedisClient.Publish(matchCancelChannel, encoded).Err()
+}
+
+// listenForCancellations stops the match threads of this replica whose match
+// was cancelled on any replica
+func listenForCancellations() {
+	pubsub := redis.RedisClient.Subscribe(matchCancelChannel)
+	// Waits for the subscription so no cancellation of a registered match is missed
+	if _, err := pubsub.Receive(); err != nil {
+		slog.Error("Error subscribing to match cancellations", "error", err)
+	}
+
+	go func() {
+		for message := range pubsub.Channel() {
+			var cancelled cancelMessage
+			if err := json.Unmarshal([]byte(message.Payload), &cancelled); err != nil {
+				slog.Error("Error parsing match cancellation", "error", err)
+				continue
+			}
+
+			cancellationsMutex.Lock()
+			cancel, ok := cancellations[cancelled.MatchId]
+			delete(cancellations, cancelled.MatchId)
+			cancellationsMutex.Unlock()
+			if ok {
+				cancel.requeue = cancelled.Requeue
+				close(cancel.done)
+			}
+		}
+	}()
+}
+
+// clearCancelledMatch runs on the match thread once the match was cancelled
+func clearCancelledMatch(queue constants.QueueType, matchId string, requeue bool) {
+	var playerIdsToClear []string
+	var matchPlayersToAddToQueue []model.MatchPlayer
+	for _, redisPlayer := range redis.RedisClient.HGetAll(matchId).Val() {
+		matchPlayer := model.UnmarshalMatchPlayer([]byte(redisPlayer))
+		if matchPlayer == nil {
+			continue
+		}
+
+		if requeue && matchPlayer.Option != 0 {
+			matchPlayersToAddToQueue = append(matchPlayersToAddToQueue, *matchPlayer)
+		} else {
+			ws.SendMessageToUser(matchPlayer.Id, ws.Removed, "Match was cancelled")
+		}
+		playerIdsToClear = append(playerIdsToClear, matchPlayer.Id)
+	}
+
+	// Published while the match data is there so the outcome is recorded with it
+	publishMatchEvent(events.MatchCancelled, matchId, queue, "", playerIdsToClear, "cancelled")
+
+	logger := logging.Match(matchId, queue.String())
+	logger.Info("Match cancelled, clearing match")
+	if err := ClearMatchData(matchId, &playerIdsToClear); err != nil {
+		logger.Error("Error clearing cancelled match", "error", err)
+	}
+
+	requeuePlayers(queue, matchPlayersToAddToQueue, "Match was cancelled - back to matchmaking")
+}
+
+func publishMatchEvent(eventType events.MatchEventType, matchId string, queue constants.QueueType, region constants.Region, playerIds []string, reason string) {
+	setMatchStage(matchId, eventType, playerIds)
+	switch eventType {
+	case events.MatchScheduled:
+		recordOutcome(matchId, queue, history.Scheduled, reason)
+	case events.MatchCancelled:
+		recordOutcome(matchId, queue, history.Cancelled, reason)
+	}
+	audit.Record(audit.Entry{Type: audit.EntryType(eventType), Queue: queue.String(), MatchId: matchId, Players: playerIds,
+		Details: map[string]interface{}{"region": region.String(), "reason": reason}})
+	events.PublishMatchEvent(events.MatchEvent{
+		Type:    eventType,
+		MatchId: matchId,
+		Queue:   queue.String(),
+		Region:  region.String(),
+		Players: playerIds,
+		Reason:  reason,
+	})
+}
+
+func ticketTraceParents(tickets []model.Ticket) []string {
+	traceParents := make([]string, 0, len(tickets))
+	for _, ticket := range tickets {
+		traceParents = append(traceParents, ticket.Member.TraceParent)
+	}
+	return traceParents
+}
+
+func ticketPlayerIds(tickets []model.Ticket) []string {
+	playerIds := make([]string, 0, len(tickets))
+	for _, ticket := range tickets {
+		playerIds = append(playerIds, ticket.Member.Id)
+	}
+	return playerIds
+}
@@ -0,0 +1,289 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mmf/internal/audit"
+	"mmf/internal/clock"
+	"mmf/internal/constants"
+	"mmf/internal/events"
+	"mmf/internal/history"
+	"mmf/internal/logging"
+	"mmf/internal/matchid"
+	"mmf/internal/model"
+	"mmf/internal/redis"
+	"mmf/internal/stats"
+	"mmf/internal/wires"
+	"mmf/pkg/client"
+	"slices"
+	"time"
+
+	goredis "github.com/go-redis/redis"
+)
+
+// MatchInfo describes a match whose thread is running
+type MatchInfo struct {
+	MatchId   string                `json:"matchId"`
+	Queue     string                `json:"queue"`
+	Region    string                `json:"region,omitempty"`
+	Stage     events.MatchEventType `json:"stage"` // Last event of the match
+	Players   []string              `json:"players"`
+	CreatedAt int64                 `json:"createdAt"`
+}
+
+// In-flight matches of every replica are kept in Redis, each one in its own
+// key indexed by creation time. The key expires so matches of a replica that
+// crashed don't stay listed, every stage of the match refreshes it.
+const (
+	activeMatchesIndex  = "active_matches"
+	activeMatchPrefix   = "active_match_"
+	activeMatchLifetime = time.Hour
+)
+
+func trackMatch(matchId string, queue constants.QueueType, region constants.Region, playerIds []string) {
+	match := &MatchInfo{
+		MatchId:   matchId,
+		Queue:     queue.String(),
+		Region:    region.String(),
+		Players:   playerIds,
+		CreatedAt: clock.Now().Unix(),
+	}
+	if err := saveMatchInfo(match); err != nil {
+		logging.Match(matchId, queue.String()).Error("Error tracking match", "error", err)
+	}
+}
+
+func untrackMatch(matchId string) {
+	pipe := redis.RedisClient.TxPipeline()
+	pipe.Del(activeMatchPrefix + matchId)
+	pipe.ZRem(activeMatchesIndex, matchId)
+	if _, err := pipe.Exec(); err != nil {
+		slog.Error("Error untracking match", "matchId", matchId, "error", err)
+	}
+}
+
+// setMatchStage records the last event of a tracked match
+func setMatchStage(matchId string, stage events.MatchEventType, playerIds []string) {
+	match := GetMatch(matchId)
+	if match == nil {
+		return
+	}
+	match.Stage = stage
+	match.Players = playerIds
+	if err := saveMatchInfo(match); err != nil {
+		logging.Match(matchId, match.Queue).Error("Error updating match stage", "error", err)
+	}
+}
+
+func saveMatchInfo(match *MatchInfo) error {
+	encoded, err := json.Marshal(match)
+	if err != nil {
+		return err
+	}
+
+	pipe := redis.RedisClient.TxPipeline()
+	pipe.Set(activeMatchPrefix+match.MatchId, encoded, activeMatchLifetime)
+	pipe.ZAdd(activeMatchesIndex, goredis.Z{Score: float64(match.CreatedAt), Member: match.MatchId})
+	_, err = pipe.Exec()
+	return err
+}
+
+// ListMatches returns the in-flight matches of the queue, of all queues when empty, oldest first
+func ListMatches(queue string) []MatchInfo {
+	matchIds, err := redis.RedisClient.ZRange(activeMatchesIndex, 0, -1).Result()
+	if err != nil || len(matchIds) == 0 {
+		return []MatchInfo{}
+	}
+
+	keys := make([]string, 0, len(matchIds))
+	for _, matchId := range matchIds {
+		keys = append(keys, activeMatchPrefix+matchId)
+	}
+	values, err := redis.RedisClient.MGet(keys...).Result()
+	if err != nil {
+		slog.Error("Error fetching active matches", "error", err)
+		return []MatchInfo{}
+	}
+
+	matches := make([]MatchInfo, 0, len(values))
+	expired := []interface{}{}
+	for i, value := range values {
+		encoded, ok := value.(string)
+		if !ok {
+			expired = append(expired, matchIds[i])
+			continue
+		}
+		var match MatchInfo
+		if err := json.Unmarshal([]byte(encoded), &match); err != nil {
+			continue
+		}
+		if queue == "" || match.Queue == queue {
+			matches = append(matches, match)
+		}
+	}
+	if len(expired) > 0 {
+		redis.RedisClient.ZRem(activeMatchesIndex, expired...)
+	}
+	return matches
+}
+
+// GetMatch returns the in-flight match or nil
+func GetMatch(matchId string) *MatchInfo {
+	encoded, err := redis.RedisClient.Get(activeMatchPrefix + matchId).Result()
+	if err != nil {
+		return nil
+	}
+
+	var match MatchInfo
+	if err := json.Unmarshal([]byte(encoded), &match); err != nil {
+		return nil
+	}
+	return &match
+}
+
+// GetMatchPlayers returns the responses and payments of the players of a match
+func GetMatchPlayers(matchId string) []model.MatchPlayer {
+	matchPlayers := []model.MatchPlayer{}
+	for _, redisPlayer := range redis.RedisClient.HGetAll(matchId).Val() {
+		if matchPlayer := model.UnmarshalMatchPlayer([]byte(redisPlayer)); matchPlayer != nil {
+			matchPlayers = append(matchPlayers, *matchPlayer)
+		}
+	}
+	slices.SortFunc(matchPlayers, func(a, b model.MatchPlayer) int {
+		return a.Team - b.Team
+	})
+	return matchPlayers
+}
+
+// StartMatch takes the tickets out of the queue and runs the match, same as
+// a match found by the crawler. The match isn't started when some player was
+// taken by another match first.
+func StartMatch(ctx context.Context, matchId string, queue constants.QueueType, region constants.Region, poolKey string, quality float64, tickets1 []model.Ticket, tickets2 []model.Ticket) error {
+	allTickets := append(slices.Clone(tickets1), tickets2...)
+	if err := AddMatchToRedis(matchId, tickets1, tickets2, queue); err != nil {
+		return fmt.Errorf("couldn't claim tickets of match %s - %s", matchId, err)
+	}
+	if err := history.Save(newHistoryMatch(matchId, queue, region, quality, tickets1, tickets2)); err != nil {
+		logging.Match(matchId, queue.String()).Error("Error saving match history", "error", err)
+	}
+	stats.RecordMatch(queue.String(), poolKey, allTickets, clock.Now().Unix())
+	audit.Record(audit.Entry{Type: audit.MatchCreated, Queue: queue.String(), MatchId: matchId, Players: ticketPlayerIds(allTickets),
+		Details: map[string]interface{}{
+			"region":  region.String(),
+			"poolKey": poolKey,
+			"team1":   auditTeam(tickets1),
+			"team2":   auditTeam(tickets2),
+		}})
+
+	go WaitingForMatchThread(ctx, matchId, queue, region, tickets1, tickets2)
+	return nil
+}
+
+func newHistoryMatch(matchId string, queue constants.QueueType, region constants.Region, quality float64, tickets1 []model.Ticket, tickets2 []model.Ticket) *history.Match {
+	match := &history.Match{
+		MatchId:   matchId,
+		Queue:     queue.String(),
+		Region:    region.String(),
+		Quality:   quality,
+		Outcome:   history.InProgress,
+		CreatedAt: clock.Now().Unix(),
+	}
+	for team, tickets := range [][]model.Ticket{tickets1, tickets2} {
+		for _, ticket := range tickets {
+			match.Players = append(match.Players, history.Player{Id: ticket.Member.Id, Team: team + 1, Elo: ticket.Score, Role: ticket.Role})
+		}
+	}
+	if data, _ := client.FindMatchingCustomData(tickets1[0], tickets2[0]); data != nil {
+		match.Stake = string(data.Collateral)
+	}
+	return match
+}
+
+// recordOutcome completes the history of the match with the responses of its players
+func recordOutcome(matchId string, queue constants.QueueType, outcome history.Outcome, reason string) {
+	players := []history.Player{}
+	for _, matchPlayer := range GetMatchPlayers(matchId) {
+		players = append(players, history.Player{
+			Id:       matchPlayer.Id,
+			Team:     matchPlayer.Team,
+			Elo:      matchPlayer.Score,
+			Role:     matchPlayer.Role,
+			Accepted: matchPlayer.Option == 2,
+			Paid:     matchPlayer.Paid,
+			TxHash:   matchPlayer.TxnHash,
+			Stake:    matchPlayer.Amount,
+		})
+	}
+
+	if err := history.Complete(matchId, outcome, reason, players); err != nil {
+		logging.Match(matchId, queue.String()).Error("Error saving match outcome", "error", err)
+	}
+}
+
+// Players of a team with their rating at match time
+func auditTeam(tickets []model.Ticket) []map[string]interface{} {
+	team := make([]map[string]interface{}, 0, len(tickets))
+	for _, ticket := range tickets {
+		player := map[string]interface{}{"id": ticket.Member.Id, "elo": ticket.Score}
+		if ticket.Role != 0 {
+			player["role"] = ticket.Role
+		}
+		team = append(team, player)
+	}
+	return team
+}
+
+// CreateMatch starts a match of the chosen players, each must have a ticket in the queue
+func CreateMatch(ctx context.Context, queue constants.QueueType, region constants.Region, team1 []string, team2 []string) (string, error) {
+	if len(team1) == 0 || len(team1) != len(team2) {
+		return "", fmt.Errorf("teams must have the same number of players")
+	}
+	if constants.IsLichessQueue(queue.String()) && len(team1) != 1 {
+		return "", fmt.Errorf("lichess matches are played one on one")
+	}
+
+	seen := make(map[string]bool)
+	getTickets := func(playerIds []string) ([]model.Ticket, error) {
+		tickets := make([]model.Ticket, 0, len(playerIds))
+		for _, playerId := range playerIds {
+			if seen[playerId] {
+				return nil, fmt.Errorf("player %s is chosen more than once", playerId)
+			}
+			seen[playerId] = true
+
+			ticket := wires.Instance.TicketService.GetTicket(queue.String(), playerId)
+			if ticket == nil {
+				return nil, fmt.Errorf("player %s has no ticket in the queue", playerId)
+			}
+			tickets = append(tickets, *ticket)
+		}
+		return tickets, nil
+	}
+
+	tickets1, err := getTickets(team1)
+	if err != nil {
+		return "", err
+	}
+	tickets2, err := getTickets(team2)
+	if err != nil {
+		return "", err
+	}
+
+	poolKey := ""
+	if constants.IsLichessQueue(queue.String()) {
+		data, _ := client.FindMatchingCustomData(tickets1[0], tickets2[0])
+		if data == nil {
+			return "", fmt.Errorf("players have no common game preferences")
+		}
+		poolKey = data.Key()
+	}
+
+	// The match outlives the request that created it
+	matchId := matchid.New(queue)
+	if err := StartMatch(context.WithoutCancel(ctx), matchId, queue, region, poolKey, 0, tickets1, tickets2); err != nil {
+		return "", err
+	}
+	return matchId, nil
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"log/slog"
+	"mmf/internal/audit"
+	"mmf/internal/logging"
+	"mmf/internal/metrics"
+	"mmf/internal/model"
+	"mmf/internal/redis"
+	ws "mmf/internal/server/websockets"
+	"mmf/internal/wires"
+)
+
+// FlushQueue removes every ticket of the queue and tells the players, it
+// returns the number of tickets removed
+func FlushQueue(queue string) (int, error) {
+	tickets := wires.Instance.TicketService.GetAllTickets(queue)
+	if tickets == nil {
+		tickets = &[]model.Ticket{}
+	}
+
+	if err := wires.Instance.TicketService.ClearQueue(queue); err != nil {
+		return 0, err
+	}
+
+	for _, ticket := range *tickets {
+		ws.RemoveFromQueue(ticket.Member.Id, queue, "Queue was flushed")
+	}
+	return len(*tickets), nil
+}
+
+// ReapExpiredTickets removes tickets whose owner stopped sending heartbeats
+// together with the user state left behind, it returns the number of tickets removed
+func ReapExpiredTickets(queue string) (int, error) {
+	expired, err := wires.Instance.TicketService.ExpiredTickets(queue)
+	if err != nil || len(expired) == 0 {
+		return 0, err
+	}
+
+	members := make([]model.MemberData, 0, len(expired))
+	for _, ticket := range expired {
+		members = append(members, ticket.Member)
+	}
+	removed, err := wires.Instance.TicketService.RemoveTickets(queue, members)
+	if err != nil || len(removed) == 0 {
+		return 0, err
+	}
+
+	// Tickets claimed by a match meanwhile aren't expired anymore
+	for _, member := range removed {
+		userId := member.Id
+		// The state of a player picked for a match meanwhile belongs to the match
+		userState := ws.GetUserState(userId)
+		if userState.State != model.NoState && (userState.MatchId == "" || redis.RedisClient.Exists(userState.MatchId).Val() == 0) {
+			if err := DeleteUserState(userId); err != nil {
+				logging.User(userId, queue).Error("Error deleting user state of expired ticket", "error", err)
+			}
+		}
+
+		ws.RemoveFromQueue(userId, queue, "Ticket expired")
+		audit.Record(audit.Entry{Type: audit.TicketExpired, Queue: queue, UserId: userId,
+			Details: map[string]interface{}{"joinedAt": member.JoinedAt}})
+	}
+
+	metrics.TicketsExpired.WithLabelValues(queue).Add(float64(len(removed)))
+	slog.Info("Removed expired tickets", "queue", queue, "count", len(removed))
+	return len(removed), nil
+}
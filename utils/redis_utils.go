@@ -1,30 +1,24 @@
 package utils
 
 import (
-	"encoding/json"
-	"log"
+	"fmt"
 	"mmf/internal/constants"
 	"mmf/internal/model"
 	"mmf/internal/redis"
+	"mmf/internal/wires"
+	"slices"
 )
 
-func AddMatchToRedis(matchId string, tickets1 []model.Ticket, tickets2 []model.Ticket, queue constants.QueueType) {
-	for _, ticket := range tickets1 {
-		memberJSON, err := json.Marshal(ticket.Member)
-		if err != nil {
-			log.Println("Error serializing MemberData:", err)
-			continue // skip this iteration if there's an error
-		}
-		redis.RedisClient.ZRem(constants.GetIndexNameQueue(queue), memberJSON)
+// AddMatchToRedis claims the tickets of the match and stores its players, it
+// fails without side effects when some ticket is no longer in the queue
+func AddMatchToRedis(matchId string, tickets1 []model.Ticket, tickets2 []model.Ticket, queue constants.QueueType) error {
+	// A match is never written over another one, its players would be mixed up
+	if redis.RedisClient.Exists(matchId).Val() > 0 {
+		return fmt.Errorf("match %s already exists", matchId)
 	}
 
-	for _, ticket := range tickets2 {
-		memberJSON, err := json.Marshal(ticket.Member)
-		if err != nil {
-			log.Println("Error serializing MemberData:", err)
-			continue // skip this iteration if there's an error
-		}
-		redis.RedisClient.ZRem(constants.GetIndexNameQueue(queue), memberJSON)
+	if err := claimTickets(matchId, queue, append(slices.Clone(tickets1), tickets2...)); err != nil {
+		return err
 	}
 
 	userState := model.UserGlobalState{State: model.MatchFound, MatchId: matchId}
@@ -34,6 +28,12 @@ func AddMatchToRedis(matchId string, tickets1 []model.Ticket, tickets2 []model.T
 		matchPlayer.Id = ticket.Member.Id
 		matchPlayer.Score = ticket.Score
 		matchPlayer.WalletAddress = ticket.Member.WalletAddress
+		matchPlayer.Regions = ticket.Member.Regions
+		matchPlayer.Pings = ticket.Member.Pings
+		matchPlayer.Roles = ticket.Member.Roles
+		matchPlayer.Role = ticket.Role
+		matchPlayer.NoExpiry = ticket.Member.NoExpiry
+		matchPlayer.JoinedAt = ticket.Member.JoinedAt
 		redis.RedisClient.HSet(matchId, ticket.Member.Id, matchPlayer.Marshal())
 		redis.RedisClient.HSet("user_state", ticket.Member.Id, userState.Marshal())
 	}
@@ -43,9 +43,34 @@ func AddMatchToRedis(matchId string, tickets1 []model.Ticket, tickets2 []model.T
 		matchPlayer.Id = ticket.Member.Id
 		matchPlayer.Score = ticket.Score
 		matchPlayer.WalletAddress = ticket.Member.WalletAddress
+		matchPlayer.Regions = ticket.Member.Regions
+		matchPlayer.Pings = ticket.Member.Pings
+		matchPlayer.Roles = ticket.Member.Roles
+		matchPlayer.Role = ticket.Role
+		matchPlayer.NoExpiry = ticket.Member.NoExpiry
+		matchPlayer.JoinedAt = ticket.Member.JoinedAt
 		redis.RedisClient.HSet(matchId, ticket.Member.Id, matchPlayer.Marshal())
 		redis.RedisClient.HSet("user_state", ticket.Member.Id, userState.Marshal())
 	}
+
+	return nil
+}
+
+// claimTickets takes the tickets out of the queue, all of them or none. A
+// ticket can be claimed by another match or removed meanwhile.
+func claimTickets(matchId string, queue constants.QueueType, tickets []model.Ticket) error {
+	members := make([]model.MemberData, 0, len(tickets))
+	for _, ticket := range tickets {
+		members = append(members, ticket.Member)
+	}
+	claimed, err := wires.Instance.TicketService.ClaimTickets(queue.String(), members)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return fmt.Errorf("tickets of match %s are no longer in the queue", matchId)
+	}
+	return nil
 }
 
 func SetUserStateInRedis(userId string, userState *model.UserGlobalState) error {
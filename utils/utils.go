@@ -2,56 +2,120 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"mmf/config"
+	"mmf/internal/audit"
+	"mmf/internal/clock"
 	"mmf/internal/constants"
+	"mmf/internal/events"
+	"mmf/internal/logging"
+	"mmf/internal/metrics"
 	"mmf/internal/model"
 	"mmf/internal/redis"
 	ws "mmf/internal/server/websockets"
+	"mmf/internal/tracing"
 	"mmf/internal/wires"
 	"mmf/pkg/client"
 	"mmf/pkg/external"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func WaitingForMatchThread(matchId string, queue constants.QueueType, tickets1 []model.Ticket, tickets2 []model.Ticket) {
+func WaitingForMatchThread(ctx context.Context, matchId string, queue constants.QueueType, region constants.Region, tickets1 []model.Ticket, tickets2 []model.Ticket) {
 	mmCfg := config.GlobalConfig.MMRConfig
-	ticker := time.NewTicker(2 * time.Second)
+	ticker := clock.NewTicker(2 * time.Second)
 	defer ticker.Stop()
-	matchInfoLog := fmt.Sprintf("Queue: %s, MatchId: %s, Player1: %s, Player2: %s", queue, matchId, tickets1[0].Member.Id, tickets2[0].Member.Id)
+	logger := logging.Match(matchId, queue.String()).With("region", region.String())
 
 	allTickets := append(tickets1, tickets2...)
-	timeToAccept := time.Now().Add(time.Duration(mmCfg.TimeToAccept) * time.Second)
+	cancel := registerMatch(matchId)
+	defer lockInMatch(matchId)
+	trackMatch(matchId, queue, region, ticketPlayerIds(allTickets))
+	defer untrackMatch(matchId)
+
+	// The match outlives the evaluation, its span is a new root linked to the
+	// evaluation and to the submission of every ticket, each stage is a child span
+	ctx, span := tracing.StartLinked(ctx, "match", ticketTraceParents(allTickets),
+		attribute.String("matchId", matchId), attribute.String("queue", queue.String()), attribute.String("region", region.String()))
+	defer span.End()
+	stages := tracing.NewStages(ctx)
+	defer stages.End()
+	stages.Next("match.accept")
+
+	timeToAccept := clock.Now().Add(time.Duration(mmCfg.TimeToAccept) * time.Second)
 
 	userState := model.UserGlobalState{State: model.MatchFound, MatchId: matchId, ExpiryTime: timeToAccept.Unix()}
 	for _, ticket := range allTickets {
 		if err := SetUserStateInRedis(ticket.Member.Id, &userState); err != nil {
-			log.Println("Error setting user state to match found: ", err)
+			logger.Error("Error setting user state to match found", "error", err)
 		}
 	}
 
 	ws.SendMatchFoundToPlayers(matchId, allTickets, timeToAccept.Unix())
-
-	for range ticker.C {
-		if time.Now().After(timeToAccept) {
-			log.Println("Players failed to accept in time ", matchInfoLog)
-			MatchFailedReturnPlayersToMM(queue, matchId, false, false)
+	publishMatchEvent(events.MatchFound, matchId, queue, region, ticketPlayerIds(allTickets), "")
+
+	for {
+		select {
+		case <-cancel.done:
+			logger.Info("Match cancelled while accepting")
+			tracing.Fail(span, "cancelled")
+			clearCancelledMatch(queue, matchId, cancel.requeue)
 			return
+		case <-ticker.C():
+		}
+
+		// Players who declined, or didn't answer in time, are replaced when the
+		// queue allows it, the match is cancelled otherwise
+		timedOut := clock.Now().After(timeToAccept)
+		if timedOut {
+			countAcceptTimeouts(queue, matchId)
 		}
 
 		allAccepted := true
+		replaced := false
 		for _, redisPlayer := range redis.RedisClient.HGetAll(matchId).Val() {
 			matchPlayer := model.UnmarshalMatchPlayer([]byte(redisPlayer))
+			declined := matchPlayer.Option == 0
+			missed := declined || (timedOut && matchPlayer.Option == 1)
+
+			if missed && canBackfill(queue) {
+				logger.Info("Player did not accept, looking for replacement", "userId", matchPlayer.Id, "timedOut", !declined)
+				reason := "You've declined the match"
+				if !declined {
+					reason = "Time for accepting the match expired"
+				}
+				replacement := backfillPlayer(queue, region, matchId, matchPlayer, reason)
+				if replacement != nil {
+					tickets1 = replaceTicket(tickets1, matchPlayer.Id, *replacement)
+					tickets2 = replaceTicket(tickets2, matchPlayer.Id, *replacement)
+					allTickets = append(slices.Clone(tickets1), tickets2...)
+					publishMatchEvent(events.PlayerReplaced, matchId, queue, region, ticketPlayerIds(allTickets), matchPlayer.Id)
+
+					replaced = true
+					allAccepted = false
+					continue
+				}
+				logger.Info("No replacement found", "userId", matchPlayer.Id)
+			}
 
-			if matchPlayer.Option == 0 {
+			if missed {
 				ticker.Stop()
-				log.Printf("Player %s did not accept - %s \n", matchPlayer.Id, matchInfoLog)
+				if declined {
+					logger.Info("Player did not accept", "userId", matchPlayer.Id)
+					tracing.Fail(span, "declined")
+				} else {
+					logger.Info("Players failed to accept in time")
+					tracing.Fail(span, "accept timeout")
+				}
 				MatchFailedReturnPlayersToMM(queue, matchId, false, false)
 				return
 			}
@@ -61,24 +125,38 @@ func WaitingForMatchThread(matchId string, queue constants.QueueType, tickets1 [
 			}
 		}
 
+		if replaced {
+			// Replacements get a fresh window to accept, everyone is told the new deadline
+			timeToAccept = clock.Now().Add(time.Duration(mmCfg.TimeToAccept) * time.Second)
+			extendAcceptWindow(queue, matchId, allTickets, timeToAccept.Unix())
+			continue
+		}
+
 		if allAccepted {
 			break
 		}
 	}
 
-	log.Println("Creating match on chain ", matchInfoLog)
-	_, err := createLichessMatchShowdown(tickets1, tickets2, matchId)
-	if err != nil {
-		// logic to return players to matchmaking
-		log.Println("Error while creating match on showdown ", err.Error())
-		return
+	publishMatchEvent(events.MatchAccepted, matchId, queue, region, ticketPlayerIds(allTickets), "")
+
+	// Only lichess matches are created on chain before the payment, team
+	// queues go straight to the payment
+	if constants.IsLichessQueue(queue.String()) {
+		logger.Info("Creating match on chain")
+		if _, err := createLichessMatchShowdown(stages.Next("match.create_onchain"), tickets1, tickets2, matchId); err != nil {
+			logger.Error("Error while creating match on showdown", "error", err)
+			stages.Fail(err.Error())
+			tracing.Fail(span, "on-chain creation failed")
+			MatchFailedReturnPlayersToMM(queue, matchId, false, true)
+			return
+		}
 	}
-	end := time.Now().Add(time.Duration(mmCfg.TimeToCancelMatch) * time.Second)
+	end := clock.Now().Add(time.Duration(mmCfg.TimeToCancelMatch) * time.Second)
 
 	userState = model.UserGlobalState{State: model.PaymentPending, MatchId: matchId, ExpiryTime: end.Unix()}
 	for _, ticket := range allTickets {
 		if err := SetUserStateInRedis(ticket.Member.Id, &userState); err != nil {
-			log.Println("Error setting user state to payment pending: ", err)
+			logger.Error("Error setting user state to payment pending", "error", err)
 		}
 	}
 
@@ -86,13 +164,25 @@ func WaitingForMatchThread(matchId string, queue constants.QueueType, tickets1 [
 	for _, ticket := range allTickets {
 		ws.SendJSONToUser(ticket.Member.Id, ws.Info, paymentResponse)
 	}
+	publishMatchEvent(events.PaymentPending, matchId, queue, region, ticketPlayerIds(allTickets), "")
 
+	paymentCtx := stages.Next("match.payment")
 	noOfChecks := 1
 
-	for range ticker.C {
-		if time.Now().After(end) {
+	for {
+		select {
+		case <-cancel.done:
+			logger.Info("Match cancelled while paying")
+			tracing.Fail(span, "cancelled")
+			clearCancelledMatch(queue, matchId, cancel.requeue)
+			return
+		case <-ticker.C():
+		}
+
+		if clock.Now().After(end) {
 			ticker.Stop()
-			log.Println("Players failed to pay in time ", matchInfoLog)
+			logger.Info("Players failed to pay in time")
+			tracing.Fail(span, "payment timeout")
 			MatchFailedReturnPlayersToMM(queue, matchId, true, false)
 			return
 		}
@@ -110,12 +200,15 @@ func WaitingForMatchThread(matchId string, queue constants.QueueType, tickets1 [
 			// make subgraph calls once every 6 seconds
 			if len(unPaidPlayersList) != 0 && noOfChecks%3 == 0 {
 				// check user's payment status from subgraph as well
-				playersPaymentStatus := client.GetQPUsersPaymentStatusFromSubgraph(matchId)
+				playersPaymentStatus := client.GetQPUsersPaymentStatusFromSubgraph(paymentCtx, matchId)
 				for _, playerInfo := range unPaidPlayersList {
 					playerWalletAddress := strings.ToLower(playerInfo.WalletAddress)
-					if playersPaymentStatus[playerWalletAddress] {
+					if amount, paid := playersPaymentStatus[playerWalletAddress]; paid {
 						playerInfo.Paid = true
+						playerInfo.Amount = amount
 						redis.RedisClient.HSet(matchId, playerInfo.Id, playerInfo.Marshal())
+						audit.Record(audit.Entry{Type: audit.PlayerPaid, Queue: queue.String(), MatchId: matchId, UserId: playerInfo.Id,
+							Details: map[string]interface{}{"walletAddress": playerWalletAddress, "amount": amount, "source": "subgraph"}})
 
 						userPlayerInfo := ws.GetUserState(playerInfo.Id)
 						userPlayerInfo.State = model.Paid
@@ -134,32 +227,60 @@ func WaitingForMatchThread(matchId string, queue constants.QueueType, tickets1 [
 	}
 
 	ticker.Stop()
+	// Past this point the match is being created on the game server
+	if locked, requeue := lockInMatch(matchId); !locked {
+		logger.Info("Match cancelled before scheduling")
+		tracing.Fail(span, "cancelled")
+		clearCancelledMatch(queue, matchId, requeue)
+		return
+	}
+
+	scheduleCtx := stages.Next("match.schedule")
+
 	switch queue {
 	case constants.D2Queue:
-		client.ScheduleDota2Match(tickets1, tickets2)
+		logger.Info("Players paid, scheduling dota 2 match")
+		if err := client.ScheduleDota2Match(scheduleCtx, tickets1, tickets2, matchId, queue.String(), region); err != nil {
+			logger.Error("Error scheduling dota 2 match", "error", err)
+			stages.Fail(err.Error())
+			tracing.Fail(span, "scheduling failed")
+			MatchFailedReturnPlayersToMM(queue, matchId, false, true)
+			return
+		}
 	case constants.CS2Queue:
-		client.ScheduleCS2Match(tickets1, tickets2)
+		logger.Info("Players paid, starting map veto")
+		mapName := RunMapVeto(matchId, tickets1, tickets2)
+		if err := client.ScheduleCS2Match(scheduleCtx, tickets1, tickets2, matchId, mapName, region); err != nil {
+			logger.Error("Error scheduling cs2 match", "error", err)
+			stages.Fail(err.Error())
+			tracing.Fail(span, "scheduling failed")
+			MatchFailedReturnPlayersToMM(queue, matchId, false, true)
+			return
+		}
 	case constants.LCQueue:
-		log.Println("Players paid, scheduling lichess match ", matchInfoLog)
-		_, err := client.ScheduleLichessMatch(tickets1, tickets2, matchId)
+		logger.Info("Players paid, scheduling lichess match")
+		_, err := client.ScheduleLichessMatch(scheduleCtx, tickets1, tickets2, matchId)
 		// TODO: Cancel the match øn the contract
 		if err != nil {
-			log.Println("Error scheduling lichess match: ", err)
+			logger.Error("Error scheduling lichess match", "error", err)
+			stages.Fail(err.Error())
+			tracing.Fail(span, "scheduling failed")
 			MatchFailedReturnPlayersToMM(queue, matchId, false, true)
 			return
 		}
 	}
-	log.Println("Match scheduled successfully - disconnecting users", matchInfoLog)
+	logger.Info("Match scheduled successfully - disconnecting users")
+	publishMatchEvent(events.MatchScheduled, matchId, queue, region, ticketPlayerIds(allTickets), "")
 
 	DisconnectAllUsers(matchId)
 	ret := redis.RedisClient.Del(matchId)
 	if ret.Err() != nil {
-		log.Println("Error deleting match from redis: ", ret.Err())
+		logger.Error("Error deleting match from redis", "error", ret.Err())
 	}
 
 	cmd := redis.RedisClient.HDel("user_state", tickets1[0].Member.Id, tickets2[0].Member.Id)
 	if cmd.Err() != nil {
-		log.Println("Error deleting user state from redis: ", cmd.Err())
+		logger.Error("Error deleting user state from redis", "error", cmd.Err())
 	}
 
 	if queue == constants.LCQueue {
@@ -185,21 +306,30 @@ func WaitingForMatchThread(matchId string, queue constants.QueueType, tickets1 [
 				RefId:    matchId,
 			}
 
-			external.SendNotification(notification)
+			external.SendNotification(scheduleCtx, notification)
 
 			md.Opponent = tickets1[0].Member.Id
 			notification.UserIds = []string{tickets2[0].Member.Id}
 			notification.Metadata = md
 
-			external.SendNotification(notification)
+			external.SendNotification(scheduleCtx, notification)
 		}()
 	}
 }
 
+func countAcceptTimeouts(queue constants.QueueType, matchId string) {
+	for _, redisPlayer := range redis.RedisClient.HGetAll(matchId).Val() {
+		matchPlayer := model.UnmarshalMatchPlayer([]byte(redisPlayer))
+		if matchPlayer != nil && matchPlayer.Option == 1 {
+			metrics.MatchResponses.WithLabelValues(queue.String(), metrics.ResponseTimeout).Inc()
+		}
+	}
+}
+
 func DisconnectAllUsers(matchId string) {
 	for _, redisPlayer := range redis.RedisClient.HGetAll(matchId).Val() {
 		matchPlayer := model.UnmarshalMatchPlayer([]byte(redisPlayer))
-		log.Println("Disconnecting user: ", matchPlayer.Id)
+		slog.Info("Disconnecting user", "matchId", matchId, "userId", matchPlayer.Id)
 		ws.DisconnectUser(matchPlayer.Id)
 	}
 }
@@ -211,7 +341,7 @@ func MatchFailedReturnPlayersToMM(queue constants.QueueType, matchId string, isP
 	for _, redisPlayer := range redis.RedisClient.HGetAll(matchId).Val() {
 		var matchPlayer model.MatchPlayer
 		if err := json.Unmarshal([]byte(redisPlayer), &matchPlayer); err != nil {
-			log.Println(err)
+			logging.Match(matchId, queue.String()).Error("Error parsing match player", "error", err)
 			return
 		}
 
@@ -237,37 +367,55 @@ func MatchFailedReturnPlayersToMM(queue constants.QueueType, matchId string, isP
 		playerIdsToClear = append(playerIdsToClear, matchPlayer.Id)
 	}
 
-	log.Println("Clearing Match ID:", matchId, " Queue: ", queue)
+	// Published while the match data is there so the outcome is recorded with it
+	publishMatchEvent(events.MatchCancelled, matchId, queue, "", playerIdsToClear, cancelReason(isPaymentFlow, isPostPayment))
+
+	logging.Match(matchId, queue.String()).Info("Clearing match", "reason", cancelReason(isPaymentFlow, isPostPayment))
 	ClearMatchData(matchId, &playerIdsToClear)
 
-	// Add them back to queue after clearing match data
-	for _, matchPlayer := range matchPlayersToAddToQueue {
-		_, err := wires.Instance.TicketService.SubmitTicket(model.SubmitTicketRequest{
+	message := "Opponent didn't accept the match, back to matchmaking"
+	if isPostPayment {
+		// happens when schedule lichess match fails
+		message = "Couldn't create match, match is cancelled - back to matchmaking"
+	}
+	requeuePlayers(queue, matchPlayersToAddToQueue, message)
+}
+
+func cancelReason(isPaymentFlow bool, isPostPayment bool) string {
+	switch {
+	case isPostPayment:
+		return "scheduling failed"
+	case isPaymentFlow:
+		return "payment expired"
+	default:
+		return "not accepted"
+	}
+}
+
+// requeuePlayers adds the players of a dissolved match back to the queue
+func requeuePlayers(queue constants.QueueType, matchPlayers []model.MatchPlayer, message string) {
+	for _, matchPlayer := range matchPlayers {
+		_, err := wires.Instance.TicketService.SubmitTicket(context.Background(), model.SubmitTicketRequest{
 			Id:                matchPlayer.Id,
 			Elo:               matchPlayer.Score,
 			WalletAddress:     matchPlayer.WalletAddress,
 			LichessCustomData: matchPlayer.LichessCustomData,
+			Regions:           matchPlayer.Regions,
+			Pings:             matchPlayer.Pings,
+			Roles:             matchPlayer.Roles,
+			NoExpiry:          matchPlayer.NoExpiry,
+			JoinedAt:          matchPlayer.JoinedAt,
 		}, queue.String())
-		log.Println("Added player:", matchPlayer.Id, "back to", queue)
-
 		if err != nil {
-			log.Println("Error adding player to queue: ", err)
+			logging.User(matchPlayer.Id, queue.String()).Error("Error adding player back to queue", "error", err)
 			continue
 		}
-		message := ws.BackToMatchMakingResponse{
-			Message: "",
+		logging.User(matchPlayer.Id, queue.String()).Info("Added player back to queue")
+		ws.SendJSONToUser(matchPlayer.Id, ws.Info, ws.BackToMatchMakingResponse{
+			Message: message,
 			State:   model.RejoinQueue,
-		}
-		if isPostPayment {
-			// happens when schedule lichess match fails
-			message.Message = "Couldn't create match, match is cancelled - back to matchmaking"
-		} else {
-			message.Message = "Opponent didn't accept the match, back to matchmaking"
-		}
-		ws.SendJSONToUser(matchPlayer.Id, ws.Info, message)
-
+		})
 	}
-
 }
 
 type CreateLichessMatchShowdownRequest struct {
@@ -287,9 +435,9 @@ type QuickPlayResponse struct {
 	Hash string `json:"txHash"`
 }
 
-func createLichessMatchShowdown(tickets1 []model.Ticket, tickets2 []model.Ticket, matchId string) (*string, error) {
+func createLichessMatchShowdown(ctx context.Context, tickets1 []model.Ticket, tickets2 []model.Ticket, matchId string) (*string, error) {
 	if len(tickets1) == 0 || len(tickets2) == 0 {
-		log.Println("Insufficient players to schedule a match")
+		slog.Warn("Insufficient players to schedule a match", "matchId", matchId)
 		return nil, errors.New("insufficient players to schedule a match")
 	}
 
@@ -317,23 +465,27 @@ func createLichessMatchShowdown(tickets1 []model.Ticket, tickets2 []model.Ticket
 	player1Wallet := tickets1[0].Member.WalletAddress
 	player2Wallet := tickets2[0].Member.WalletAddress
 
-	time, increment, collateral := client.FindTimeIncrAndColl(tickets1[0], tickets2[0])
+	customData, _ := client.FindMatchingCustomData(tickets1[0], tickets2[0])
+	if customData == nil {
+		return nil, errors.New("players have no common game preferences")
+	}
+
 	showdownReq := &CreateLichessMatchShowdownRequest{
 		MatchID:       matchId,
 		Player1ID:     player1,
 		Player2ID:     player2,
 		Player1Wallet: player1Wallet,
 		Player2Wallet: player2Wallet,
-		Collateral:    collateral,
-		Increment:     increment,
-		Time:          time,
-		Variant:       "blitz",
-		Rated:         false,
+		Collateral:    customData.Collateral,
+		Increment:     customData.Increment,
+		Time:          customData.Time,
+		Variant:       customData.PerfType(),
+		Rated:         customData.Rated,
 	}
 
 	url := fmt.Sprintf("%s/chess/create_quickplay_match", config.GlobalConfig.ShowdownApi.URL)
-	log.Println(url)
-	client := &http.Client{}
+	slog.Debug("Creating match on showdown", "matchId", matchId, "url", url)
+	client := metrics.HTTPClient("showdown")
 
 	jsonData, err := json.Marshal(showdownReq)
 
@@ -341,7 +493,7 @@ func createLichessMatchShowdown(tickets1 []model.Ticket, tickets2 []model.Ticket
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 
 	if err != nil {
 		return nil, err
@@ -371,7 +523,6 @@ func createLichessMatchShowdown(tickets1 []model.Ticket, tickets2 []model.Ticket
 		return nil, err
 	}
 
-	log.Println("CREATED MATCH ON SHOWDOWN API")
-	log.Println(quickPlayResponse.Hash)
+	slog.Info("Created match on showdown api", "matchId", matchId, "txHash", quickPlayResponse.Hash)
 	return &quickPlayResponse.Hash, nil
 }
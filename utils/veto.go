@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"log/slog"
+	"math/rand"
+	"mmf/config"
+	"mmf/internal/clock"
+	"mmf/internal/constants"
+	"mmf/internal/model"
+	"mmf/internal/redis"
+	ws "mmf/internal/server/websockets"
+	"slices"
+	"time"
+)
+
+// RunMapVeto lets team captains alternately ban maps from the configured pool
+// until a single map remains, a captain who doesn't ban in time gets a random ban
+func RunMapVeto(matchId string, tickets1 []model.Ticket, tickets2 []model.Ticket) string {
+	cs2Cfg := config.GlobalConfig.CS2Match
+	allTickets := append(tickets1, tickets2...)
+	vetoKey := constants.GetVetoKey(matchId)
+	defer redis.RedisClient.Del(vetoKey)
+
+	veto := &model.MapVeto{
+		MatchId:   matchId,
+		Captains:  []string{getCaptain(tickets1), getCaptain(tickets2)},
+		Remaining: slices.Clone(cs2Cfg.MapPool),
+		Banned:    []string{},
+		Turn:      1,
+		Round:     1,
+	}
+
+	if len(veto.Remaining) == 0 {
+		slog.Warn("CS2 map pool is empty, falling back to de_dust2", "matchId", matchId)
+		return "de_dust2"
+	}
+
+	ticker := clock.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for len(veto.Remaining) > 1 {
+		expiry := clock.Now().Add(time.Duration(cs2Cfg.VetoTimeout) * time.Second)
+		veto.ExpiryTime = expiry.Unix()
+		// The round is written with the state so a ban sent during the switch
+		// can't count for the next captain
+		pipe := redis.RedisClient.TxPipeline()
+		pipe.HDel(vetoKey, "ban")
+		pipe.HSet(vetoKey, "state", veto.Marshal())
+		pipe.HSet(vetoKey, "round", veto.Round)
+		if _, err := pipe.Exec(); err != nil {
+			slog.Error("Error storing map veto", "matchId", matchId, "error", err)
+		}
+		ws.SendMapVetoToPlayers(veto, allTickets)
+
+		bannedMap := ""
+		for range ticker.C() {
+			if ban := redis.RedisClient.HGet(vetoKey, "ban").Val(); slices.Contains(veto.Remaining, ban) {
+				bannedMap = ban
+				break
+			}
+
+			if clock.Now().After(expiry) {
+				bannedMap = veto.Remaining[rand.Intn(len(veto.Remaining))]
+				slog.Info("Captain didn't ban in time, auto banning", "matchId", matchId, "userId", veto.CurrentCaptain(), "map", bannedMap)
+				break
+			}
+		}
+
+		veto.Remaining = slices.DeleteFunc(veto.Remaining, func(m string) bool { return m == bannedMap })
+		veto.Banned = append(veto.Banned, bannedMap)
+		veto.Turn = 3 - veto.Turn
+		veto.Round++
+	}
+
+	veto.Map = veto.Remaining[0]
+	veto.ExpiryTime = 0
+	ws.SendMapVetoToPlayers(veto, allTickets)
+
+	return veto.Map
+}
+
+// Highest rated player of the team is the captain
+func getCaptain(tickets []model.Ticket) string {
+	captain := tickets[0]
+	for _, ticket := range tickets[1:] {
+		if ticket.Score > captain.Score {
+			captain = ticket
+		}
+	}
+	return captain.Member.Id
+}